@@ -0,0 +1,199 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultBreakerCooldown is how long the circuit breaker stays open when
+// BreakerOptions.Cooldown is 0.
+const DefaultBreakerCooldown = 30 * time.Second
+
+// BreakerState is the state of the circuit breaker around the Graph API.
+type BreakerState int
+
+const (
+	// BreakerClosed means that requests are sent as usual.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen means that requests fail straight away with a
+	// *CircuitOpenError.
+	BreakerOpen
+	// BreakerHalfOpen means that a single request is let through to probe
+	// whether the Graph API has recovered.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerOptions are the settings of the circuit breaker around the Graph API.
+// The breaker counts network errors, timeouts and server errors, not errors
+// caused by the request itself.
+type BreakerOptions struct {
+	// Failures is the number of consecutive failures which open the breaker.
+	// 0 disables the breaker.
+	Failures int
+	// Cooldown is how long the breaker stays open before letting a probe
+	// request through. 0 means DefaultBreakerCooldown.
+	Cooldown time.Duration
+	// OnStateChange, if not nil, is called whenever the breaker changes
+	// state, eg. to raise an alert when it opens.
+	OnStateChange func(from, to BreakerState)
+}
+
+// CircuitOpenError is returned instead of sending a request while the circuit
+// breaker is open.
+type CircuitOpenError struct {
+	// Until is when the breaker will let a probe request through.
+	Until time.Time
+}
+
+// Error implements the error interface.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("messenger: circuit breaker open until %v", e.Until.Format(time.RFC3339))
+}
+
+// breaker is a circuit breaker which fails fast while the Graph API is down.
+type breaker struct {
+	opts BreakerOptions
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	until    time.Time
+	probing  bool
+}
+
+// newBreaker creates a breaker, or returns nil if it is disabled.
+func newBreaker(opts BreakerOptions) *breaker {
+	if opts.Failures <= 0 {
+		return nil
+	}
+
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultBreakerCooldown
+	}
+
+	return &breaker{opts: opts}
+}
+
+// allow returns a *CircuitOpenError if a request may not be sent. probe is
+// whether the request is the one let through to probe a half-open breaker.
+func (b *breaker) allow() (probe bool, err error) {
+	if b == nil {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	from := b.state
+
+	switch {
+	case b.state == BreakerOpen && time.Now().Before(b.until),
+		b.state == BreakerHalfOpen && b.probing:
+		b.mu.Unlock()
+		return false, &CircuitOpenError{Until: b.until}
+	case b.state == BreakerOpen:
+		b.state = BreakerHalfOpen
+	}
+
+	if b.state == BreakerHalfOpen {
+		b.probing = true
+		probe = true
+	}
+
+	to := b.state
+	b.mu.Unlock()
+
+	b.changed(from, to)
+	return probe, nil
+}
+
+// record counts the outcome of a request which allow let through. probe is
+// what allow returned for it. While the breaker is not closed, only the
+// outcome of the probe counts, as requests let through before the breaker
+// opened say nothing about whether the Graph API has recovered.
+func (b *breaker) record(probe, failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	from := b.state
+
+	switch {
+	case probe && failed:
+		b.probing = false
+		b.open()
+	case probe:
+		b.probing = false
+		b.failures = 0
+		b.state = BreakerClosed
+	case b.state != BreakerClosed:
+	case failed:
+		b.failures++
+		if b.failures >= b.opts.Failures {
+			b.open()
+		}
+	default:
+		b.failures = 0
+	}
+
+	to := b.state
+	b.mu.Unlock()
+
+	b.changed(from, to)
+}
+
+// release lets go of a request which allow let through without counting its
+// outcome, eg. because its caller gave up on it.
+func (b *breaker) release(probe bool) {
+	if b == nil || !probe {
+		return
+	}
+
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// open opens the breaker for the cooldown. b.mu must be held.
+func (b *breaker) open() {
+	b.state = BreakerOpen
+	b.until = time.Now().Add(b.opts.Cooldown)
+}
+
+// changed reports a change of state to the hook.
+func (b *breaker) changed(from, to BreakerState) {
+	if from != to && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(from, to)
+	}
+}
+
+// breakerFailure is whether the outcome of a request counts as a failure of
+// the Graph API.
+func breakerFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500
+}
+
+// isCircuitOpen is whether err was caused by the circuit breaker being open.
+func isCircuitOpen(err error) (*CircuitOpenError, bool) {
+	var ce *CircuitOpenError
+	ok := errors.As(err, &ce)
+	return ce, ok
+}
@@ -0,0 +1,182 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	// Steps: "allow" and "deny" call allow expecting it to let a request
+	// through or not, "fail" and "ok" record the outcome of the last request
+	// let through, "stale fail" and "stale ok" that of a request let through
+	// before the breaker opened, "release" lets go of the last request, and
+	// "cool" ends the cooldown.
+	tests := []struct {
+		name  string
+		steps []string
+		want  BreakerState
+		// changes are the transitions reported to OnStateChange.
+		changes [][2]BreakerState
+	}{
+		{
+			name:  "stays closed below the threshold",
+			steps: []string{"allow", "fail", "allow", "fail", "allow", "ok", "allow", "fail", "allow", "fail"},
+			want:  BreakerClosed,
+		},
+		{
+			name:    "opens at the threshold",
+			steps:   []string{"allow", "fail", "allow", "fail", "allow", "fail", "deny"},
+			want:    BreakerOpen,
+			changes: [][2]BreakerState{{BreakerClosed, BreakerOpen}},
+		},
+		{
+			name:  "lets a single probe through after the cooldown",
+			steps: []string{"allow", "fail", "allow", "fail", "allow", "fail", "cool", "allow", "deny"},
+			want:  BreakerHalfOpen,
+			changes: [][2]BreakerState{
+				{BreakerClosed, BreakerOpen},
+				{BreakerOpen, BreakerHalfOpen},
+			},
+		},
+		{
+			name:  "closes when the probe succeeds",
+			steps: []string{"allow", "fail", "allow", "fail", "allow", "fail", "cool", "allow", "ok", "allow"},
+			want:  BreakerClosed,
+			changes: [][2]BreakerState{
+				{BreakerClosed, BreakerOpen},
+				{BreakerOpen, BreakerHalfOpen},
+				{BreakerHalfOpen, BreakerClosed},
+			},
+		},
+		{
+			name:  "ignores requests which finish while half-open",
+			steps: []string{"allow", "fail", "allow", "fail", "allow", "fail", "cool", "allow", "stale ok", "stale fail", "deny"},
+			want:  BreakerHalfOpen,
+			changes: [][2]BreakerState{
+				{BreakerClosed, BreakerOpen},
+				{BreakerOpen, BreakerHalfOpen},
+			},
+		},
+		{
+			name:  "lets another probe through when one is released",
+			steps: []string{"allow", "fail", "allow", "fail", "allow", "fail", "cool", "allow", "release", "allow", "ok"},
+			want:  BreakerClosed,
+			changes: [][2]BreakerState{
+				{BreakerClosed, BreakerOpen},
+				{BreakerOpen, BreakerHalfOpen},
+				{BreakerHalfOpen, BreakerClosed},
+			},
+		},
+		{
+			name:  "does not count released requests",
+			steps: []string{"allow", "fail", "allow", "fail", "allow", "release", "allow", "ok", "allow", "fail"},
+			want:  BreakerClosed,
+		},
+		{
+			name:  "opens again when the probe fails",
+			steps: []string{"allow", "fail", "allow", "fail", "allow", "fail", "cool", "allow", "fail", "deny"},
+			want:  BreakerOpen,
+			changes: [][2]BreakerState{
+				{BreakerClosed, BreakerOpen},
+				{BreakerOpen, BreakerHalfOpen},
+				{BreakerHalfOpen, BreakerOpen},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes [][2]BreakerState
+			b := newBreaker(BreakerOptions{
+				Failures: 3,
+				Cooldown: time.Hour,
+				OnStateChange: func(from, to BreakerState) {
+					changes = append(changes, [2]BreakerState{from, to})
+				},
+			})
+
+			var probe bool
+			for i, step := range tt.steps {
+				switch step {
+				case "allow":
+					var err error
+					if probe, err = b.allow(); err != nil {
+						t.Fatalf("step %v: allow() = %v, want nil", i, err)
+					}
+				case "deny":
+					_, err := b.allow()
+					if _, ok := isCircuitOpen(err); !ok {
+						t.Fatalf("step %v: allow() = %v, want *CircuitOpenError", i, err)
+					}
+				case "fail":
+					b.record(probe, true)
+				case "ok":
+					b.record(probe, false)
+				case "stale fail":
+					b.record(false, true)
+				case "stale ok":
+					b.record(false, false)
+				case "release":
+					b.release(probe)
+				case "cool":
+					b.until = time.Now().Add(-time.Second)
+				}
+			}
+
+			if b.state != tt.want {
+				t.Errorf("state = %v, want %v", b.state, tt.want)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("changes = %v, want %v", changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(BreakerOptions{})
+	if b != nil {
+		t.Fatalf("newBreaker() = %v, want nil", b)
+	}
+
+	for i := 0; i < 10; i++ {
+		b.record(false, true)
+	}
+	if _, err := b.allow(); err != nil {
+		t.Errorf("allow() = %v, want nil", err)
+	}
+}
+
+func TestBreakerIgnoresCancelledRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The caller gives up while the request is in flight.
+	var states []BreakerState
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		cancel()
+		return nil, req.Context().Err()
+	})}
+	m := New(Options{
+		Token:      "token",
+		HTTPClient: client,
+		Logger:     NopLogger,
+		Breaker: BreakerOptions{
+			Failures: 1,
+			OnStateChange: func(from, to BreakerState) {
+				states = append(states, to)
+			},
+		},
+	})
+
+	if _, err := m.ProfileByID(ctx, 0, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ProfileByID() = %v, want %v", err, context.Canceled)
+	}
+	if len(states) != 0 {
+		t.Errorf("breaker went %v, want closed", states)
+	}
+}
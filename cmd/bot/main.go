@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/RuniVN/messenger"
@@ -22,6 +26,7 @@ var (
 	verifyToken = conf.String("verify-token", os.Getenv("DELIVR_VERIFY_TOKEN"), "The token used to verify facebook")
 	verify      = conf.Bool("should-verify", false, "Whether or not the app should verify itself")
 	pageToken   = conf.String("page-token", os.Getenv("DELIVR_ACCESS_TOKEN"), "The token that is used to verify the page on facebook")
+	appSecret   = conf.String("app-secret", os.Getenv("DELIVR_APP_SECRET"), "The secret of the facebook app, used to check the signatures of webhooks")
 )
 
 var db *gorm.DB
@@ -30,94 +35,130 @@ var err error
 func main() {
 	handleDatabaseStuff()
 
+	// Webhooks change which customer a user is linked to, so they must be
+	// checked to come from facebook
+	if *appSecret == "" {
+		fmt.Println("An app secret is required, set DELIVR_APP_SECRET")
+		os.Exit(1)
+	}
+
 	// Create a new messenger client
 	client := messenger.New(messenger.Options{
 		Verify:      *verify,
 		VerifyToken: *verifyToken,
 		Token:       *pageToken,
+		AppSecret:   *appSecret,
+		Retry:       messenger.DefaultRetryPolicy,
 	})
 
-	// Setup a handler to be triggered when a message is delivered
-	client.HandlePostBack(func(d messenger.PostBack, r *messenger.Response) {
+	// Setup handlers to be triggered when one of the menu buttons is pressed
+	client.OnPostback("Buy", withSession(func(userSession model.UserSession, r *messenger.Response) {
+		err = r.Text("Xin bạn vui lòng paste link vào đây nhé")
+		if err != nil {
+			fmt.Println("Cannot send to recipient")
+		}
+	}))
 
-		var userSession model.UserSession
-		err = db.Where("fid = ?", d.Sender.ID).First(&userSession).Error
+	client.OnPostback("Search", withSession(func(userSession model.UserSession, r *messenger.Response) {
+		err = r.WithMetadata(model.StatusMetadata(model.StatusCheckOrder)).Text("Xin bạn cho biết mã đơn hàng")
 		if err != nil {
-			fmt.Println("Cannot get user session")
+			fmt.Println("Cannot send to recipient")
+		}
+
+		userSession.Status = model.StatusCheckOrder
+		err = db.Save(userSession).Error
+		if err != nil {
+			fmt.Sprintln("Cannot save user session %s", err.Error())
+			handleError(r)
 			return
 		}
+	}))
 
-		switch d.Payload {
-		case "Buy":
-			err = r.Text("Xin bạn vui lòng paste link vào đây nhé")
-			if err != nil {
-				fmt.Println("Cannot send to recipient")
-			}
-		case "Search":
-			err = r.Text("Xin bạn cho biết mã đơn hàng")
-			if err != nil {
-				fmt.Println("Cannot send to recipient")
-			}
+	client.OnPostback("Cancel", withSession(func(userSession model.UserSession, r *messenger.Response) {
+		err = r.WithMetadata(model.StatusMetadata(model.StatusCancelOrder)).Text("Bạn đã chọn hủy đơn hàng. Xin cho biết mã đơn hàng.")
+		if err != nil {
+			fmt.Println("Cannot send to recipient")
+		}
 
-			userSession.Status = model.StatusCheckOrder
-			err = db.Save(userSession).Error
-			if err != nil {
-				fmt.Sprintln("Cannot save user session %s", err.Error())
-				handleError(r)
-				return
-			}
-		case "Cancel":
-			err = r.Text("Bạn đã chọn hủy đơn hàng. Xin cho biết mã đơn hàng.")
-			if err != nil {
-				fmt.Println("Cannot send to recipient")
-			}
+		userSession.Status = model.StatusCancelOrder
+		err = db.Save(userSession).Error
+		if err != nil {
+			fmt.Sprintln("Cannot save user session %s", err.Error())
+			handleError(r)
+			return
+		}
+	}))
 
-			userSession.Status = model.StatusCancelOrder
-			err = db.Save(userSession).Error
-			if err != nil {
-				fmt.Sprintln("Cannot save user session %s", err.Error())
-				handleError(r)
-				return
-			}
-		case "Yes":
-			userSession.Status = model.StatusGreeting
-			err = db.Save(userSession).Error
-			if err != nil {
-				fmt.Println("Cannot save user session")
-				return
-			}
+	client.OnPostback("Yes", withSession(func(userSession model.UserSession, r *messenger.Response) {
+		userSession.Status = model.StatusGreeting
+		err = db.Save(userSession).Error
+		if err != nil {
+			fmt.Println("Cannot save user session")
+			return
+		}
+
+		r.Text("Bạn vui lòng nhập thêm link vào đây")
+	}))
+
+	client.OnPostback("No", withSession(func(userSession model.UserSession, r *messenger.Response) {
+		userSession.Status = model.StatusGetEmail
+		err = db.Save(userSession).Error
+		if err != nil {
+			fmt.Println("Cannot save user session")
+			return
+		}
+		r.WithMetadata(model.StatusMetadata(model.StatusGetEmail)).Text("Vui lòng cho chúng tôi xin email của bạn")
+	}))
 
-			r.Text("Bạn vui lòng nhập thêm link vào đây")
-		case "No":
-			userSession.Status = model.StatusGetEmail
-			err = db.Save(userSession).Error
+	client.OnPostbackDefault(func(p messenger.PostBack, _ messenger.Params, r *messenger.Response) {
+		fmt.Println("Unknown postback:", p.Payload)
+	})
+
+	// Setup a handler to be triggered when the page sends a message
+	client.HandleEcho(func(m messenger.Message, r *messenger.Response) {
+		if status, ok := model.ParseStatusMetadata(m.Metadata); ok {
+			fmt.Printf("Prompt for status %v sent to %v\n", status, m.Recipient.ID)
+		}
+	})
+
+	// Setup a handler to be triggered when a user links their delivr.to
+	// account. The website hands out an authorization code, which is
+	// exchanged for the ID of the customer it was issued to
+	client.HandleAccountLinking(func(a messenger.AccountLinking, r *messenger.Response) {
+		accountID := ""
+		if a.Linked() {
+			id, err := lookupAccount(a.AuthorizationCode)
 			if err != nil {
-				fmt.Println("Cannot save user session")
+				fmt.Println("Cannot check authorization code:", err)
+				handleError(r)
 				return
 			}
-			r.Text("Vui lòng cho chúng tôi xin email của bạn")
+			accountID = id
+		}
 
+		err := db.Model(&model.FUser{}).Where("fid = ?", a.Sender.ID).Update("account_id", accountID).Error
+		if err != nil {
+			fmt.Println("Cannot update account of user")
 		}
 	})
 
+	// Setup global commands which work whatever step the conversation is in
+	client.OnText(messenger.IgnoreAccents("menu").Global().When(inSession), showMenu)
+	client.OnText(messenger.IgnoreAccents("restart").Global().When(inSession), showMenu)
+	client.OnText(messenger.IgnoreAccents("help").Global(), func(m messenger.Message, _ messenger.Params, r *messenger.Response) {
+		r.Text("Gõ \"menu\" để xem các lựa chọn, hoặc \"restart\" để bắt đầu lại từ đầu.")
+	})
+
 	// Setup a handler to be triggered when a message is received
 	client.HandleMessage(func(m messenger.Message, r *messenger.Response) {
 		fmt.Printf("%v (Sent, %v)\n", m.Text, m.Time.Format(time.UnixDate))
 
-		p, err := client.ProfileByID(m.Sender.ID)
+		p, err := client.ProfileByID(r.Context(), r.PageID(), m.Sender.ID)
 		if err != nil {
 			fmt.Println("Something went wrong!", err)
 		}
 		if !checkUserInSession(m.Sender.ID) {
-			var buttonTemplate []messenger.StructuredMessageButton
-			buttonBuy := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Mua hàng", Payload: "Buy"}
-			buttonSearch := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Tra cứu đơn hàng", Payload: "Search"}
-			buttonCancel := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Hủy mua hàng", Payload: "Cancel"}
-			buttonTemplate = append(buttonTemplate, buttonBuy)
-			buttonTemplate = append(buttonTemplate, buttonCancel)
-			buttonTemplate = append(buttonTemplate, buttonSearch)
-
-			err = r.ButtonTemplate("Chào bạn, đây là delivr.to, bạn muốn làm gì?", &buttonTemplate)
+			err = sendMenu(r)
 			if err != nil {
 				fmt.Println("Cannot send to recipient")
 				return
@@ -357,15 +398,7 @@ func main() {
 					return
 				}
 
-				var buttonTemplate []messenger.StructuredMessageButton
-				buttonBuy := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Mua hàng", Payload: "Buy"}
-				buttonSearch := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Tra cứu đơn hàng", Payload: "Search"}
-				buttonCancel := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Hủy mua hàng", Payload: "Cancel"}
-				buttonTemplate = append(buttonTemplate, buttonBuy)
-				buttonTemplate = append(buttonTemplate, buttonCancel)
-				buttonTemplate = append(buttonTemplate, buttonSearch)
-
-				err = r.ButtonTemplate("Chào bạn, đây là delivr.to, bạn muốn làm gì?", &buttonTemplate)
+				err = sendMenu(r)
 				if err != nil {
 					fmt.Println("Cannot send to recipient")
 					return
@@ -467,11 +500,25 @@ func main() {
 	// fmt.Println("Read at:", m.Watermark().Format(time.UnixDate))
 	/* }) */
 
-	fmt.Println("Serving messenger bot on localhost:8080")
-
-	http.ListenAndServe("localhost:8080", client.Handler())
+	// Warn about events which have handlers but are not sent to the webhook
+	if _, err := client.CheckSubscriptions(context.Background(), 0); err != nil {
+		fmt.Println("Cannot check webhook subscriptions:", err)
+	}
 
 	scheduler.Every().Day().At("00:01").Run(jobClearSession)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Serving messenger bot on localhost:8080")
+
+	err = client.Serve(ctx, "localhost:8080", messenger.ServeOptions{
+		Ready: db.DB().Ping,
+	})
+	if err != nil {
+		fmt.Println("Cannot serve messenger bot:", err)
+		os.Exit(1)
+	}
 }
 
 func handleDatabaseStuff() {
@@ -509,6 +556,86 @@ func handleDatabaseStuff() {
 	}
 }
 
+// sendMenu sends the main menu of the bot.
+func sendMenu(r *messenger.Response) error {
+	var buttonTemplate []messenger.StructuredMessageButton
+	buttonBuy := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Mua hàng", Payload: "Buy"}
+	buttonSearch := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Tra cứu đơn hàng", Payload: "Search"}
+	buttonCancel := messenger.StructuredMessageButton{Type: "postback", URL: "", Title: "Hủy mua hàng", Payload: "Cancel"}
+	buttonTemplate = append(buttonTemplate, buttonBuy)
+	buttonTemplate = append(buttonTemplate, buttonCancel)
+	buttonTemplate = append(buttonTemplate, buttonSearch)
+
+	return r.ButtonTemplate("Chào bạn, đây là delivr.to, bạn muốn làm gì?", &buttonTemplate)
+}
+
+// showMenu takes the user back to the start of the conversation.
+func showMenu(m messenger.Message, _ messenger.Params, r *messenger.Response) {
+	err := db.Model(&model.UserSession{}).Where("fid = ?", m.Sender.ID).Update("status", model.StatusGreeting).Error
+	if err != nil {
+		fmt.Println("Cannot save user session")
+		handleError(r)
+		return
+	}
+
+	err = sendMenu(r)
+	if err != nil {
+		fmt.Println("Cannot send to recipient")
+	}
+}
+
+// inSession reports whether the sender of m has an active session.
+func inSession(m messenger.Message) bool {
+	return checkUserInSession(m.Sender.ID)
+}
+
+// withSession wraps a postback handler so that it is given the session of the
+// user who pressed the button.
+func withSession(f func(model.UserSession, *messenger.Response)) messenger.PayloadHandler {
+	return func(p messenger.PostBack, _ messenger.Params, r *messenger.Response) {
+		var userSession model.UserSession
+		err := db.Where("fid = ?", p.Sender.ID).First(&userSession).Error
+		if err != nil {
+			fmt.Println("Cannot get user session")
+			return
+		}
+
+		f(userSession, r)
+	}
+}
+
+// lookupAccount asks the website for the ID of the customer code, the
+// authorization code of an account linking, was issued to.
+func lookupAccount(code string) (string, error) {
+	req, err := http.NewRequest("GET", "http://localhost:8008/api/bot/accounts?authorization_code="+url.QueryEscape(code), nil)
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("response status: %v", resp.Status)
+	}
+
+	var response struct {
+		AccountID string `json:"account_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", err
+	}
+	if response.AccountID == "" {
+		return "", fmt.Errorf("no account for authorization code")
+	}
+
+	return response.AccountID, nil
+}
+
 func checkUserExist(fid int64) bool {
 	var count int
 	err := db.Model(&model.FUser{}).Where("fid = ?", fid).Count(&count).Error
@@ -0,0 +1,130 @@
+// Command replay sends the webhooks recorded in a journal, see
+// messenger.Journal, to a running bot.
+//
+//	replay -url http://localhost:8080/ -speed 10 -sender 1234 webhooks.jsonl
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/RuniVN/messenger"
+)
+
+var (
+	target    = flag.String("url", "http://localhost:8080/", "The webhook URL to replay to")
+	speed     = flag.Float64("speed", 0, "How much faster than real time to replay, 0 replays as fast as possible")
+	sender    = flag.String("sender", "", "Only replay webhooks with an event from this sender ID")
+	from      = flag.String("from", "", "Only replay webhooks received from this time, in RFC 3339")
+	to        = flag.String("to", "", "Only replay webhooks received until this time, in RFC 3339")
+	appSecret = flag.String("app-secret", os.Getenv("DELIVR_APP_SECRET"), "Sign the webhooks again with this app secret")
+)
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] journal...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	opts, err := replayOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	h := forward{url: *target, client: &http.Client{Timeout: messenger.DefaultTimeout}}
+
+	total := 0
+	for _, path := range flag.Args() {
+		n, err := replayFile(ctx, path, h, opts)
+		total += n
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot replay", path+":", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Println("Replayed", total, "webhooks")
+}
+
+// replayOptions builds the options of the replay from the flags.
+func replayOptions() (messenger.ReplayOptions, error) {
+	opts := messenger.ReplayOptions{
+		Speed:     *speed,
+		AppSecret: *appSecret,
+		OnResult: func(e messenger.JournalEntry, status int) {
+			if status < 200 || status > 299 {
+				fmt.Println("Webhook received at", e.Time.Format(time.RFC3339), "was answered with", status)
+			}
+		},
+	}
+
+	var err error
+	if *sender != "" {
+		if opts.Sender, err = strconv.ParseInt(*sender, 10, 64); err != nil {
+			return opts, fmt.Errorf("invalid sender: %v", err)
+		}
+	}
+	if *from != "" {
+		if opts.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return opts, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if *to != "" {
+		if opts.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return opts, fmt.Errorf("invalid to: %v", err)
+		}
+	}
+
+	return opts, nil
+}
+
+// replayFile replays the journal at path.
+func replayFile(ctx context.Context, path string, h http.Handler, opts messenger.ReplayOptions) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return messenger.Replay(ctx, f, h, opts)
+}
+
+// forward is an http.Handler which sends requests on to a remote URL.
+type forward struct {
+	url    string
+	client *http.Client
+}
+
+func (f forward) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, f.url, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header = r.Header
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot send webhook:", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, resp.Body)
+	w.WriteHeader(resp.StatusCode)
+}
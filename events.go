@@ -0,0 +1,308 @@
+package messenger
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EventType is used to determine what kind of event a webhook event is.
+type EventType string
+
+const (
+	// UnknownEvent means that the event was not able to be classified.
+	UnknownEvent EventType = "unknown"
+	// MessageEvent means that the event was a message (May contain text,
+	// attachments or a quick reply).
+	MessageEvent EventType = "message"
+	// DeliveryEvent means that the event was advising of a successful delivery
+	// to a previous recipient.
+	DeliveryEvent EventType = "delivery"
+	// ReadEvent means that the event was a previous recipient reading their
+	// respective messages.
+	ReadEvent EventType = "read"
+	// PostBackEvent means that the event was a postback callback.
+	PostBackEvent EventType = "postback"
+	// EchoEvent means that the event was an echo of a message sent by the
+	// page, either by an app or by a person managing the page.
+	EchoEvent EventType = "echo"
+	// OptInEvent means that the event was a person opting in through a "Send
+	// to Messenger" or checkbox plugin.
+	OptInEvent EventType = "optin"
+	// ReferralEvent means that the event was a person already in a
+	// conversation with the page following an m.me link, an ad or the
+	// customer chat plugin.
+	ReferralEvent EventType = "referral"
+	// AccountLinkingEvent means that the event was a person linking or
+	// unlinking their account through a log in or log out button.
+	AccountLinkingEvent EventType = "account_linking"
+	// PassThreadControlEvent means that the event was control of the
+	// conversation being passed to the app.
+	PassThreadControlEvent EventType = "pass_thread_control"
+	// TakeThreadControlEvent means that the event was control of the
+	// conversation being taken from the app.
+	TakeThreadControlEvent EventType = "take_thread_control"
+	// RequestThreadControlEvent means that the event was another app asking
+	// the app to pass control of the conversation.
+	RequestThreadControlEvent EventType = "request_thread_control"
+)
+
+// Event is a single event fired by the webhook, of any type.
+type Event struct {
+	// Type is what kind of event this is.
+	Type EventType
+	// PageID is the ID of the page the event was sent to.
+	PageID int64
+	// Sender is who the event was sent from.
+	Sender Sender
+	// Recipient is who the event was sent to.
+	Recipient Recipient
+	// Time is when the event was triggered.
+	Time time.Time
+	// Raw is the event as it was sent by Facebook.
+	Raw json.RawMessage
+	// Payload is the decoded contents of the event, eg. a Message for a
+	// MessageEvent. Nil for an UnknownEvent.
+	Payload interface{}
+	// Standby is whether the event was received while another app controlled
+	// the conversation. Responses to standby events are passive.
+	Standby bool
+}
+
+// EventHandler is a handler used for responding to an event of any type.
+type EventHandler func(Event, *Response)
+
+// EventDecoder decodes raw, the value of the field identifying a type of
+// event, into e.Payload. It returns false if raw does not describe its type
+// of event, in which case the remaining types are tried.
+type EventDecoder func(raw json.RawMessage, e *Event) (bool, error)
+
+// eventKind is a type of event registered with the Messenger.
+type eventKind struct {
+	typ    EventType
+	field  string
+	decode EventDecoder
+	// replyTo returns who responses to the event go to. Nil means the
+	// sender of the event.
+	replyTo func(Event) Recipient
+	// route is given the first chance to handle an event. Events it handles
+	// are not passed on to the EventHandlers.
+	route func(Event, *Response) bool
+}
+
+// RegisterEvent adds a type of event to the Messenger. Webhook events carrying
+// field are decoded by decode and passed to the EventHandlers added with
+// Handle for t. Types are tried in the order they were registered, after the
+// types built into the Messenger.
+func (m *Messenger) RegisterEvent(t EventType, field string, decode EventDecoder) {
+	m.kinds = append(m.kinds, eventKind{typ: t, field: field, decode: decode})
+}
+
+// Handle adds an EventHandler to the Messenger which will be triggered when an
+// event of type t is received. Handlers added for UnknownEvent are triggered
+// by any event which does not match a registered type.
+func (m *Messenger) Handle(t EventType, f EventHandler) {
+	if m.handlers == nil {
+		m.handlers = map[EventType][]EventHandler{}
+	}
+	m.handlers[t] = append(m.handlers[t], f)
+}
+
+// decodeEvent determines what type of event info is and decodes it. The
+// returned eventKind is nil for an UnknownEvent.
+func (m *Messenger) decodeEvent(info MessageInfo, entry Entry) (Event, *eventKind, error) {
+	e := Event{
+		Type:      UnknownEvent,
+		PageID:    entry.ID,
+		Sender:    info.Sender,
+		Recipient: info.Recipient,
+		Time:      time.Unix(info.Timestamp, 0),
+		Raw:       info.Raw,
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(info.Raw, &fields); err != nil {
+		return e, nil, err
+	}
+
+	for i := range m.kinds {
+		k := &m.kinds[i]
+
+		raw, ok := fields[k.field]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+
+		ok, err := k.decode(raw, &e)
+		if err != nil {
+			return e, nil, err
+		}
+
+		if ok {
+			e.Type = k.typ
+			return e, k, nil
+		}
+	}
+
+	return e, nil, nil
+}
+
+// decodeMessage decodes a MessageEvent.
+func decodeMessage(raw json.RawMessage, e *Event) (bool, error) {
+	var message Message
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return false, err
+	}
+
+	message.Sender = e.Sender
+	message.Recipient = e.Recipient
+	message.Time = e.Time
+	e.Payload = message
+
+	return true, nil
+}
+
+// decodeEcho decodes an EchoEvent. Messages which are not echoes are left for
+// decodeMessage.
+func decodeEcho(raw json.RawMessage, e *Event) (bool, error) {
+	var echo struct {
+		IsEcho bool `json:"is_echo"`
+	}
+	if err := json.Unmarshal(raw, &echo); err != nil {
+		return false, err
+	}
+
+	if !echo.IsEcho {
+		return false, nil
+	}
+
+	return decodeMessage(raw, e)
+}
+
+// replyToRecipient is used for events sent by the page, such as echoes.
+func replyToRecipient(e Event) Recipient {
+	return e.Recipient
+}
+
+// decodeOptIn decodes an OptInEvent.
+func decodeOptIn(raw json.RawMessage, e *Event) (bool, error) {
+	var optIn OptIn
+	if err := json.Unmarshal(raw, &optIn); err != nil {
+		return false, err
+	}
+
+	optIn.Sender = e.Sender
+	optIn.Recipient = e.Recipient
+	optIn.Time = e.Time
+	e.Payload = optIn
+
+	return true, nil
+}
+
+// replyToOptIn sends responses to the user_ref of checkbox plugin opt ins,
+// which do not have a sender.
+func replyToOptIn(e Event) Recipient {
+	optIn := e.Payload.(OptIn)
+	if e.Sender.ID == 0 && optIn.UserRef != "" {
+		return Recipient{UserRef: optIn.UserRef}
+	}
+	return Recipient{ID: e.Sender.ID}
+}
+
+// decodeReferral decodes a ReferralEvent.
+func (m *Messenger) decodeReferral(raw json.RawMessage, e *Event) (bool, error) {
+	var referral Referral
+	if err := json.Unmarshal(raw, &referral); err != nil {
+		return false, err
+	}
+
+	referral.Sender = e.Sender
+	referral.Recipient = e.Recipient
+	referral.Time = e.Time
+	m.verifyReferral(&referral)
+	e.Payload = referral
+
+	return true, nil
+}
+
+// verifyReferral checks the signature of the ref of r if refs are signed.
+func (m *Messenger) verifyReferral(r *Referral) {
+	if len(m.refSecret) == 0 || r.Ref == "" {
+		return
+	}
+
+	r.Ref, r.Verified = VerifyRef(m.refSecret, r.Ref)
+}
+
+// decodeAccountLinking decodes an AccountLinkingEvent.
+func decodeAccountLinking(raw json.RawMessage, e *Event) (bool, error) {
+	var linking AccountLinking
+	if err := json.Unmarshal(raw, &linking); err != nil {
+		return false, err
+	}
+
+	linking.Sender = e.Sender
+	linking.Recipient = e.Recipient
+	linking.Time = e.Time
+	e.Payload = linking
+
+	return true, nil
+}
+
+// decodeThreadControl decodes a PassThreadControlEvent, TakeThreadControlEvent
+// or RequestThreadControlEvent.
+func decodeThreadControl(raw json.RawMessage, e *Event) (bool, error) {
+	var control ThreadControl
+	if err := json.Unmarshal(raw, &control); err != nil {
+		return false, err
+	}
+
+	control.Sender = e.Sender
+	control.Recipient = e.Recipient
+	control.Time = e.Time
+	e.Payload = control
+
+	return true, nil
+}
+
+// decodeDelivery decodes a DeliveryEvent.
+func decodeDelivery(raw json.RawMessage, e *Event) (bool, error) {
+	var delivery Delivery
+	if err := json.Unmarshal(raw, &delivery); err != nil {
+		return false, err
+	}
+
+	e.Payload = delivery
+	return true, nil
+}
+
+// decodeRead decodes a ReadEvent.
+func decodeRead(raw json.RawMessage, e *Event) (bool, error) {
+	var read Read
+	if err := json.Unmarshal(raw, &read); err != nil {
+		return false, err
+	}
+
+	e.Payload = read
+	return true, nil
+}
+
+// decodePostBack decodes a PostBackEvent.
+func (m *Messenger) decodePostBack(raw json.RawMessage, e *Event) (bool, error) {
+	var postBack PostBack
+	if err := json.Unmarshal(raw, &postBack); err != nil {
+		return false, err
+	}
+
+	postBack.Sender = e.Sender
+	postBack.Recipient = e.Recipient
+	postBack.Time = e.Time
+	if postBack.Referral != nil {
+		postBack.Referral.Sender = e.Sender
+		postBack.Referral.Recipient = e.Recipient
+		postBack.Referral.Time = e.Time
+		m.verifyReferral(postBack.Referral)
+	}
+	e.Payload = postBack
+
+	return true, nil
+}
@@ -0,0 +1,161 @@
+package messenger
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestThreadControlEvents(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		typ   EventType
+		want  ThreadControl
+	}{
+		{
+			name:  "pass",
+			event: `"pass_thread_control":{"new_owner_app_id":"123456789","metadata":"to inbox"}`,
+			typ:   PassThreadControlEvent,
+			want:  ThreadControl{NewOwnerAppID: 123456789, Metadata: "to inbox"},
+		},
+		{
+			name:  "take",
+			event: `"take_thread_control":{"previous_owner_app_id":"123456789","metadata":"back"}`,
+			typ:   TakeThreadControlEvent,
+			want:  ThreadControl{PreviousOwnerAppID: 123456789, Metadata: "back"},
+		},
+		{
+			name:  "request",
+			event: `"request_thread_control":{"requested_owner_app_id":123456789,"metadata":"please"}`,
+			typ:   RequestThreadControlEvent,
+			want:  ThreadControl{RequestedOwnerAppID: 123456789, Metadata: "please"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Options{Logger: NopLogger})
+
+			var got []ThreadControl
+			m.Handle(tt.typ, func(e Event, r *Response) {
+				got = append(got, e.Payload.(ThreadControl))
+			})
+			m.Handle(UnknownEvent, func(e Event, r *Response) {
+				t.Errorf("event decoded as unknown: %s", e.Raw)
+			})
+
+			postEvents(t, m, "messaging", `"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":1,`+tt.event)
+			if len(got) != 1 {
+				t.Fatalf("handler called %v times, want 1", len(got))
+			}
+
+			c := got[0]
+			if c.NewOwnerAppID != tt.want.NewOwnerAppID ||
+				c.PreviousOwnerAppID != tt.want.PreviousOwnerAppID ||
+				c.RequestedOwnerAppID != tt.want.RequestedOwnerAppID ||
+				c.Metadata != tt.want.Metadata {
+				t.Errorf("got %+v, want %+v", c, tt.want)
+			}
+			if c.Sender.ID != 2 {
+				t.Errorf("sender = %v, want 2", c.Sender.ID)
+			}
+		})
+	}
+}
+
+// postEvents posts a webhook from page 1 with the events, given without their
+// braces, in field to the Handler of m, and fails unless it is accepted.
+func postEvents(t *testing.T, m *Messenger, field string, events ...string) {
+	t.Helper()
+
+	body := `{"object":"page","entry":[{"id":"1","time":1,"` + field + `":[{` + strings.Join(events, "},{") + `}]}]}`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestEchoes(t *testing.T) {
+	const (
+		message = `"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":1,"message":{"mid":"m1","text":"hi"}`
+		echo    = `"sender":{"id":"1"},"recipient":{"id":"2"},"timestamp":2,"message":{"mid":"m2","text":"hello","is_echo":true,"app_id":3}`
+	)
+
+	tests := []struct {
+		name     string
+		include  bool
+		messages []string
+	}{
+		{name: "excluded", messages: []string{"m1"}},
+		{name: "included", include: true, messages: []string{"m1", "m2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Options{Logger: NopLogger, IncludeEchoes: tt.include})
+
+			var messages, echoes []string
+			m.HandleMessage(func(msg Message, r *Response) {
+				messages = append(messages, msg.Mid)
+			})
+			m.HandleEcho(func(msg Message, r *Response) {
+				echoes = append(echoes, msg.Mid)
+			})
+
+			postEvents(t, m, "messaging", message, echo)
+
+			if !reflect.DeepEqual(messages, tt.messages) {
+				t.Errorf("MessageHandler got %v, want %v", messages, tt.messages)
+			}
+			if want := []string{"m2"}; !reflect.DeepEqual(echoes, want) {
+				t.Errorf("EchoHandler got %v, want %v", echoes, want)
+			}
+		})
+	}
+}
+
+func TestStandby(t *testing.T) {
+	m := New(Options{Token: "token", Logger: NopLogger})
+
+	var (
+		standby []EventType
+		errs    []error
+	)
+	m.HandleStandby(func(e Event, r *Response) {
+		standby = append(standby, e.Type)
+		if !e.Standby || !r.Passive() {
+			t.Errorf("Standby = %v, Passive() = %v, want both true", e.Standby, r.Passive())
+		}
+		errs = append(errs, r.Text("hi"))
+	})
+	m.HandleMessage(func(msg Message, r *Response) {
+		t.Errorf("MessageHandler called with a standby message")
+	})
+	m.HandlePostBack(func(p PostBack, r *Response) {
+		t.Errorf("PostBackHandler called with a standby postback")
+	})
+	m.OnText(Regexp(`.*`), func(msg Message, p Params, r *Response) {
+		t.Errorf("TextHandler called with a standby message")
+	})
+
+	postEvents(t, m, "standby",
+		`"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":1,"message":{"mid":"m1","text":"hi"}`,
+		`"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":2,"postback":{"payload":"P"}`,
+	)
+
+	if want := []EventType{MessageEvent, PostBackEvent}; !reflect.DeepEqual(standby, want) {
+		t.Errorf("StandbyHandler got %v, want %v", standby, want)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrPassive) {
+			t.Errorf("Text() = %v, want %v", err, ErrPassive)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fanOutRetry decides how often, and how quickly, failed forwards to a
+// Downstream are retried. Downstreams may receive a webhook twice.
+var fanOutRetry = RetryPolicy{
+	Attempts:  5,
+	BaseDelay: time.Second,
+	MaxDelay:  time.Minute,
+}
+
+// Downstream is an app the webhooks received by the Messenger are forwarded
+// to, see Options.Downstreams.
+type Downstream struct {
+	// Name identifies the Downstream in logs and metrics. Empty means URL.
+	Name string
+	// URL is the webhook URL of the app.
+	URL string
+	// AppSecret, if set, signs the forwarded webhooks with X-Hub-Signature
+	// and X-Hub-Signature-256, as Facebook would for that app.
+	AppSecret string
+	// Pages, if not empty, only forwards the entries of these pages.
+	Pages []int64
+	// Events, if not empty, only forwards events of these types.
+	Events []EventType
+}
+
+// name returns the name of d for logs and metrics.
+func (d Downstream) name() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.URL
+}
+
+// forwarder forwards webhooks to the Downstreams in the background.
+type forwarder struct {
+	m           *Messenger
+	downstreams []Downstream
+	wg          sync.WaitGroup
+
+	// ctx is cancelled to stop the forwards in flight, see wait.
+	ctx  context.Context
+	stop context.CancelFunc
+
+	mu sync.Mutex
+	// closed is set once wait is called, after which nothing is forwarded.
+	closed bool
+}
+
+// newForwarder creates a forwarder to downstreams.
+func newForwarder(m *Messenger, downstreams []Downstream) *forwarder {
+	ctx, stop := context.WithCancel(context.Background())
+	return &forwarder{m: m, downstreams: downstreams, ctx: ctx, stop: stop}
+}
+
+// forward sends body, a webhook which passed the signature check, to each
+// Downstream which wants some of its events.
+func (f *forwarder) forward(body []byte) {
+	for _, d := range f.downstreams {
+		b, ok, err := f.filter(d, body)
+		if err != nil {
+			f.m.log.Warn("could not filter webhook for downstream", "downstream", d.name(), "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		f.mu.Lock()
+		if f.closed {
+			f.mu.Unlock()
+			return
+		}
+		f.wg.Add(1)
+		f.mu.Unlock()
+
+		go func(d Downstream, b []byte) {
+			defer f.wg.Done()
+			f.send(d, b)
+		}(d, b)
+	}
+}
+
+// filter returns the part of body which d wants, and whether there is any.
+// Bodies are only rewritten when d has filters, so that they are otherwise
+// forwarded byte for byte.
+func (f *forwarder) filter(d Downstream, body []byte) ([]byte, bool, error) {
+	if len(d.Pages) == 0 && len(d.Events) == 0 {
+		return body, true, nil
+	}
+
+	var rec map[string]json.RawMessage
+	if err := json.Unmarshal(body, &rec); err != nil {
+		return nil, false, err
+	}
+
+	var entries []map[string]json.RawMessage
+	if err := json.Unmarshal(rec["entry"], &entries); err != nil {
+		return nil, false, err
+	}
+
+	var kept []map[string]json.RawMessage
+	for _, entry := range entries {
+		// Page IDs are sent as strings, as in Entry.
+		var id string
+		json.Unmarshal(entry["id"], &id)
+		page, _ := strconv.ParseInt(id, 10, 64)
+
+		if len(d.Pages) > 0 && !containsPage(d.Pages, page) {
+			continue
+		}
+
+		events := 0
+		for _, field := range []string{"messaging", "standby"} {
+			raw, ok := entry[field]
+			if !ok {
+				continue
+			}
+
+			n, filtered, err := f.filterEvents(d, raw)
+			if err != nil {
+				return nil, false, err
+			}
+
+			entry[field] = filtered
+			events += n
+		}
+
+		if events > 0 {
+			kept = append(kept, entry)
+		}
+	}
+
+	if len(kept) == 0 {
+		return nil, false, nil
+	}
+
+	raw, err := json.Marshal(kept)
+	if err != nil {
+		return nil, false, err
+	}
+	rec["entry"] = raw
+
+	b, err := json.Marshal(rec)
+	return b, err == nil, err
+}
+
+// filterEvents returns how many of the events in raw d wants, and those
+// events.
+func (f *forwarder) filterEvents(d Downstream, raw json.RawMessage) (int, json.RawMessage, error) {
+	var events []json.RawMessage
+	if err := json.Unmarshal(raw, &events); err != nil {
+		return 0, nil, err
+	}
+
+	kept := []json.RawMessage{}
+	for _, event := range events {
+		if len(d.Events) > 0 {
+			var info MessageInfo
+			if err := json.Unmarshal(event, &info); err != nil {
+				return 0, nil, err
+			}
+
+			e, _, err := f.m.decodeEvent(info, Entry{})
+			if err != nil || !containsEvent(d.Events, e.Type) {
+				continue
+			}
+		}
+		kept = append(kept, event)
+	}
+
+	b, err := json.Marshal(kept)
+	return len(kept), b, err
+}
+
+// send posts body to d, retrying according to fanOutRetry, until the
+// forwarder is stopped.
+func (f *forwarder) send(d Downstream, body []byte) {
+	labels := map[string]string{"downstream": d.name()}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = f.attempt(d, body)
+		if err == nil {
+			return
+		}
+
+		f.m.metrics.Count(MetricFanOutErrors, labels)
+
+		if !retry || attempt >= fanOutRetry.Attempts {
+			break
+		}
+
+		f.m.log.Warn("could not forward webhook", "downstream", d.name(), "attempt", attempt, "error", err)
+		if serr := sleep(f.ctx, fanOutRetry.backoff(attempt)); serr != nil {
+			break
+		}
+	}
+
+	f.m.log.Error("gave up forwarding webhook", "downstream", d.name(), "error", err)
+}
+
+// attempt posts body to d once. It returns whether a failure may be retried.
+func (f *forwarder) attempt(d Downstream, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(f.ctx, "POST", d.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if d.AppSecret != "" {
+		req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(sign(sha1.New, d.AppSecret, body)))
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign(sha256.New, d.AppSecret, body)))
+	}
+
+	resp, err := f.m.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("messenger: downstream replied %v", resp.Status)
+	}
+
+	return false, nil
+}
+
+// wait waits for the forwards in flight, or for ctx to be done, then stops
+// the forwarder. Forwards which are still retrying are given up on, and
+// webhooks received afterwards are not forwarded.
+func (f *forwarder) wait(ctx context.Context) error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	f.stop()
+	<-done
+
+	return err
+}
+
+func containsPage(pages []int64, page int64) bool {
+	for _, p := range pages {
+		if p == page {
+			return true
+		}
+	}
+	return false
+}
+
+func containsEvent(types []EventType, t EventType) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signedWebhook posts body to the Handler of m signed with secret, and fails
+// unless it is accepted.
+func signedWebhook(t *testing.T, m *Messenger, secret, body string) {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signWebhook(req, secret, []byte(body))
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestForwarderStopsOnShutdown(t *testing.T) {
+	var sends int32
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&sends, 1)
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Status:     http.StatusText(http.StatusServiceUnavailable),
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	})}
+
+	m := New(Options{
+		AppSecret:   "secret",
+		HTTPClient:  client,
+		Logger:      NopLogger,
+		Downstreams: []Downstream{{URL: "http://downstream.test/"}},
+	})
+
+	signedWebhook(t, m, "secret", `{"object":"page","entry":[]}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := m.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Shutdown() took %v", d)
+	}
+
+	n := atomic.LoadInt32(&sends)
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&sends); got != n {
+		t.Errorf("%v forwards sent after Shutdown", got-n)
+	}
+
+	// Webhooks received after Shutdown are not forwarded.
+	signedWebhook(t, m, "secret", `{"object":"page","entry":[]}`)
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&sends); got != n {
+		t.Errorf("%v forwards sent after Shutdown", got-n)
+	}
+}
+
+// fanOutBody is a webhook with events on two pages, told apart by their
+// timestamps. Its spacing is kept by unfiltered forwards only.
+const fanOutBody = `{"object": "page", "entry": [
+	{"id": "1", "time": 1, "messaging": [
+		{"sender": {"id": "9"}, "recipient": {"id": "1"}, "timestamp": 1, "message": {"mid": "m1", "text": "hi"}},
+		{"sender": {"id": "9"}, "recipient": {"id": "1"}, "timestamp": 2, "delivery": {"mids": ["m0"], "watermark": 1}}
+	]},
+	{"id": "2", "time": 1, "messaging": [
+		{"sender": {"id": "9"}, "recipient": {"id": "2"}, "timestamp": 3, "message": {"mid": "m2", "text": "hi"}}
+	], "standby": [
+		{"sender": {"id": "9"}, "recipient": {"id": "2"}, "timestamp": 4, "postback": {"payload": "P"}}
+	]}
+]}`
+
+func TestForwarderFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		downstream Downstream
+		want       []int64
+	}{
+		{name: "unfiltered", want: []int64{1, 2, 3, 4}},
+		{name: "page", downstream: Downstream{Pages: []int64{2}}, want: []int64{3, 4}},
+		{name: "unknown page", downstream: Downstream{Pages: []int64{3}}},
+		{name: "event", downstream: Downstream{Events: []EventType{MessageEvent}}, want: []int64{1, 3}},
+		{name: "page and event", downstream: Downstream{Pages: []int64{2}, Events: []EventType{PostBackEvent}}, want: []int64{4}},
+		{name: "no event on page", downstream: Downstream{Pages: []int64{1}, Events: []EventType{PostBackEvent}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Options{Logger: NopLogger})
+			f := newForwarder(m, nil)
+
+			b, ok, err := f.filter(tt.downstream, []byte(fanOutBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (len(tt.want) > 0) {
+				t.Fatalf("filter() ok = %v, want %v", ok, len(tt.want) > 0)
+			}
+			if !ok {
+				return
+			}
+
+			var rec Receive
+			if err := json.Unmarshal(b, &rec); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int64
+			for _, entry := range rec.Entry {
+				for _, infos := range [][]MessageInfo{entry.Messaging, entry.Standby} {
+					for _, info := range infos {
+						got = append(got, info.Timestamp)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filter() kept events %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwarderSignatures(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = map[string]*http.Request{}
+		bodies   = map[string][]byte{}
+	)
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		requests[req.URL.Host] = req
+		bodies[req.URL.Host] = body
+		mu.Unlock()
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	})}
+
+	m := New(Options{
+		AppSecret:  "secret",
+		HTTPClient: client,
+		Logger:     NopLogger,
+		Downstreams: []Downstream{
+			{URL: "http://a.test/", AppSecret: "a"},
+			{URL: "http://b.test/", AppSecret: "b"},
+			{URL: "http://unsigned.test/"},
+		},
+	})
+
+	signedWebhook(t, m, "secret", fanOutBody)
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for host, secret := range map[string]string{"a.test": "a", "b.test": "b", "unsigned.test": ""} {
+		req, body := requests[host], bodies[host]
+		if req == nil {
+			t.Errorf("nothing forwarded to %v", host)
+			continue
+		}
+
+		if !bytes.Equal(body, []byte(fanOutBody)) {
+			t.Errorf("%v received %s, want the webhook byte for byte", host, body)
+		}
+
+		if secret == "" {
+			if req.Header.Get("X-Hub-Signature") != "" || req.Header.Get("X-Hub-Signature-256") != "" {
+				t.Errorf("%v received a signature without an AppSecret", host)
+			}
+			continue
+		}
+
+		if !validSignature(secret, req.Header, body) {
+			t.Errorf("%v received X-Hub-Signature-256 %q, not signed with its AppSecret", host, req.Header.Get("X-Hub-Signature-256"))
+		}
+		sha1Only := http.Header{"X-Hub-Signature": req.Header["X-Hub-Signature"]}
+		if !validSignature(secret, sha1Only, body) {
+			t.Errorf("%v received X-Hub-Signature %q, not signed with its AppSecret", host, req.Header.Get("X-Hub-Signature"))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package messenger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileStore is an OutboxStore which keeps each message in a JSON file, so that
+// queued messages survive restarts. Queued messages are kept in the pending
+// directory and dead letters in the dead directory of Dir. Files which cannot
+// be decoded are renamed with a .bad suffix and left for inspection.
+type FileStore struct {
+	// Dir is the directory the messages are kept in.
+	Dir string
+}
+
+// NewFileStore creates a FileStore in dir, creating the directory if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	s := &FileStore{Dir: dir}
+
+	for _, d := range []string{s.pending(), s.dead()} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Put implements OutboxStore.
+func (s *FileStore) Put(msg OutboxMessage) error {
+	return writeMessage(s.pending(), msg)
+}
+
+// Pending implements OutboxStore.
+func (s *FileStore) Pending() ([]OutboxMessage, error) {
+	return readMessages(s.pending())
+}
+
+// Delete implements OutboxStore.
+func (s *FileStore) Delete(id string) error {
+	return removeMessage(s.pending(), id)
+}
+
+// PutDead implements OutboxStore.
+func (s *FileStore) PutDead(msg OutboxMessage) error {
+	return writeMessage(s.dead(), msg)
+}
+
+// Dead implements OutboxStore.
+func (s *FileStore) Dead() ([]OutboxMessage, error) {
+	return readMessages(s.dead())
+}
+
+// DeleteDead implements OutboxStore.
+func (s *FileStore) DeleteDead(id string) error {
+	return removeMessage(s.dead(), id)
+}
+
+func (s *FileStore) pending() string {
+	return filepath.Join(s.Dir, "pending")
+}
+
+func (s *FileStore) dead() string {
+	return filepath.Join(s.Dir, "dead")
+}
+
+// writeMessage writes msg to dir. The file is written under a temporary name
+// and renamed, so that a crash never leaves a partial message behind.
+func writeMessage(dir string, msg OutboxMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, msg.ID+".json")
+	tmp := name + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+// readMessages reads the messages in dir in order of ID. Files which cannot be
+// decoded are set aside, so that one of them does not hold up the others.
+func readMessages(dir string) ([]OutboxMessage, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	msgs := make([]OutboxMessage, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			// Removed since the directory was listed.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var msg OutboxMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			path := filepath.Join(dir, name)
+			if err := os.Rename(path, path+".bad"); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// removeMessage removes the message id from dir.
+func removeMessage(dir, id string) error {
+	err := os.Remove(filepath.Join(dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,226 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// GraphURL is the base of the Graph API endpoints.
+	GraphURL = "https://graph.facebook.com/v2.6/"
+
+	// DefaultTimeout is the timeout of Graph API requests when
+	// Options.HTTPClient is nil.
+	DefaultTimeout = 30 * time.Second
+)
+
+// Error is an error returned by the Graph API.
+type Error struct {
+	// Message is the description of the error.
+	Message string `json:"message"`
+	// Type is the kind of error, eg. OAuthException.
+	Type string `json:"type"`
+	// Code is the error code.
+	Code int `json:"code"`
+	// Subcode gives more detail on the error code.
+	Subcode int `json:"error_subcode"`
+	// TraceID identifies the request when reporting a problem to Facebook.
+	TraceID string `json:"fbtrace_id"`
+	// Transient is whether the error is temporary.
+	Transient bool `json:"is_transient"`
+	// StatusCode is the HTTP status of the response.
+	StatusCode int `json:"-"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("graph: %v (code %v, status %v)", e.Message, e.Code, e.StatusCode)
+}
+
+// PageTokenProvider looks up the access token of a page, so that a single
+// Messenger can serve several pages.
+type PageTokenProvider interface {
+	// PageToken returns the access token of the page with the ID page.
+	PageToken(page int64) (string, error)
+}
+
+// PageTokens is a PageTokenProvider holding the access tokens of a fixed set
+// of pages, keyed by page ID.
+type PageTokens map[int64]string
+
+// PageToken implements PageTokenProvider.
+func (p PageTokens) PageToken(page int64) (string, error) {
+	token, ok := p[page]
+	if !ok {
+		return "", fmt.Errorf("messenger: no token for page %v", page)
+	}
+	return token, nil
+}
+
+// pageToken returns the access token of page. A page of 0 means the default
+// page, whose token is Options.TokenProvider or Options.Token.
+func (m *Messenger) pageToken(page int64) (string, error) {
+	switch {
+	case page != 0 && m.pages != nil:
+		return m.pages.PageToken(page)
+	case m.tokens != nil:
+		return m.tokens.Token()
+	case m.pages != nil:
+		return m.pages.PageToken(page)
+	}
+	return "", errNoToken
+}
+
+// graph performs a request on path of the Graph API as page. body, if not nil,
+// is sent as JSON and the response is decoded into v, if not nil.
+func (m *Messenger) graph(ctx context.Context, page int64, method, path string, query url.Values, body, v interface{}) error {
+	var b bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&b).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, GraphURL+path, &b)
+	if err != nil {
+		return err
+	}
+
+	if query != nil {
+		req.URL.RawQuery = query.Encode()
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return m.do(ctx, page, req, v, m.retry)
+}
+
+// sendMessage sends body, a request of contentType, to the Send API as page,
+// retrying according to retry. ctx carries the span the request is traced
+// under, and the send is given up on when it is done.
+func (m *Messenger) sendMessage(ctx context.Context, page int64, contentType string, body []byte, retry RetryPolicy) error {
+	req, err := http.NewRequest("POST", SendMessageURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	start := time.Now()
+	err = m.do(ctx, page, req, nil, retry)
+	m.metrics.Observe(MetricSendSeconds, nil, since(start))
+
+	return err
+}
+
+// do authenticates req as page and sends it, decoding the response into v, if
+// not nil. Failed requests are retried according to retry. The
+// request, including its retries, is traced as a child of the span in ctx, and
+// is given up on when ctx is done.
+func (m *Messenger) do(ctx context.Context, page int64, req *http.Request, v interface{}, retry RetryPolicy) (err error) {
+	req = req.WithContext(ctx)
+
+	_, span := m.traceRequest(ctx, page, req)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	token, err := m.pageToken(page)
+	if err != nil {
+		return err
+	}
+
+	query := req.URL.Query()
+	query.Set("access_token", token)
+	if m.appSecret != "" {
+		query.Set("appsecret_proof", appSecretProof(m.appSecret, token))
+	}
+	req.URL.RawQuery = query.Encode()
+
+	for attempt := 1; ; attempt++ {
+		if err := m.usage.wait(ctx); err != nil {
+			return err
+		}
+
+		err = m.attempt(req, v)
+		span.SetAttributes(Attribute{"messenger.attempts", attempt})
+		if err != nil {
+			m.metrics.Count(MetricGraphErrors, map[string]string{"code": graphErrorCode(err)})
+		}
+		if err == nil || attempt >= retry.Attempts || !retry.retryable(req.Method, err) {
+			return err
+		}
+
+		m.metrics.Count(MetricRetries, nil)
+
+		if req.GetBody != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				return err
+			}
+			req.Body = body
+		}
+
+		if err := sleep(ctx, retry.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// attempt sends req once, decoding the response into v, if not nil. It fails
+// with a *CircuitOpenError without sending req while the breaker is open.
+func (m *Messenger) attempt(req *http.Request, v interface{}) error {
+	probe, err := m.breaker.allow()
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil && req.Context().Err() != nil {
+		// The caller gave up on the request, which says nothing about the
+		// Graph API.
+		m.breaker.release(probe)
+	} else {
+		m.breaker.record(probe, breakerFailure(resp, err))
+	}
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+
+	m.usage.update(resp.Header)
+
+	return decodeGraphResponse(resp, v)
+}
+
+// decodeGraphResponse decodes a successful response into v, if not nil, or
+// returns the Error of an unsuccessful one.
+func decodeGraphResponse(resp *http.Response, v interface{}) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Error *Error `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == nil {
+			return &Error{Message: resp.Status, StatusCode: resp.StatusCode}
+		}
+
+		e.Error.StatusCode = resp.StatusCode
+		return e.Error
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
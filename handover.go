@@ -0,0 +1,93 @@
+package messenger
+
+import (
+	"context"
+	"net/url"
+)
+
+// App is an app connected to the page.
+type App struct {
+	ID   int64  `json:"id,string"`
+	Name string `json:"name"`
+}
+
+// threadControl is the request body of the handover protocol endpoints.
+type threadControl struct {
+	Recipient   Recipient `json:"recipient"`
+	TargetAppID int64     `json:"target_app_id,omitempty"`
+	Metadata    string    `json:"metadata,omitempty"`
+}
+
+// The handover protocol calls act as page, the page the conversation is with.
+// A page of 0 means the default page. They give up when ctx is done.
+
+// PassThreadControl passes control of the conversation with recipient to the
+// app targetAppID, eg. the Page Inbox so that a person can take over. While
+// another app owns the conversation, its events are sent to the Messenger as
+// standby events.
+func (m *Messenger) PassThreadControl(ctx context.Context, page, recipient, targetAppID int64, metadata string) error {
+	return m.handover(ctx, page, "pass_thread_control", threadControl{
+		Recipient:   Recipient{ID: recipient},
+		TargetAppID: targetAppID,
+		Metadata:    metadata,
+	})
+}
+
+// TakeThreadControl takes control of the conversation with recipient from the
+// app which owns it. Only the primary receiver of the page may take control.
+func (m *Messenger) TakeThreadControl(ctx context.Context, page, recipient int64, metadata string) error {
+	return m.handover(ctx, page, "take_thread_control", threadControl{
+		Recipient: Recipient{ID: recipient},
+		Metadata:  metadata,
+	})
+}
+
+// RequestThreadControl asks the primary receiver of the page to pass control
+// of the conversation with recipient.
+func (m *Messenger) RequestThreadControl(ctx context.Context, page, recipient int64, metadata string) error {
+	return m.handover(ctx, page, "request_thread_control", threadControl{
+		Recipient: Recipient{ID: recipient},
+		Metadata:  metadata,
+	})
+}
+
+// ReleaseThreadControl releases control of the conversation with recipient
+// back to the primary receiver of the page.
+func (m *Messenger) ReleaseThreadControl(ctx context.Context, page, recipient int64, metadata string) error {
+	return m.handover(ctx, page, "release_thread_control", threadControl{
+		Recipient: Recipient{ID: recipient},
+		Metadata:  metadata,
+	})
+}
+
+// SecondaryReceivers retrieves the apps which may be passed control of
+// conversations with page.
+func (m *Messenger) SecondaryReceivers(ctx context.Context, page int64) ([]App, error) {
+	var res struct {
+		Data []App `json:"data"`
+	}
+
+	query := url.Values{}
+	query.Set("fields", "id,name")
+
+	err := m.graph(ctx, page, "GET", "me/secondary_receivers", query, nil, &res)
+	return res.Data, err
+}
+
+// handover sends a request to one of the handover protocol endpoints as page.
+func (m *Messenger) handover(ctx context.Context, page int64, endpoint string, body threadControl) error {
+	var res struct {
+		Success bool `json:"success"`
+	}
+
+	err := m.graph(ctx, page, "POST", "me/"+endpoint, nil, body, &res)
+	if err != nil {
+		return err
+	}
+
+	if !res.Success {
+		return &Error{Message: endpoint + " was not successful"}
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package messenger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultJournalSize is the size, in bytes, from which a journal file is
+// rotated when Journal.MaxSize is 0.
+const DefaultJournalSize = 100 << 20
+
+// journalHeaders are the headers which are not recorded in a journal.
+var journalHeaders = []string{"Authorization", "Cookie"}
+
+// JournalEntry is a webhook request as recorded in a journal.
+type JournalEntry struct {
+	// Time is when the webhook was received.
+	Time time.Time `json:"time"`
+	// Header holds the headers of the request, including its signature.
+	Header http.Header `json:"header"`
+	// Body is the body of the request, as sent by Facebook. It is kept as a
+	// string so that it is replayed byte for byte, matching its signature.
+	Body string `json:"body"`
+}
+
+// Journal appends the raw webhooks received by a Messenger to a JSONL file,
+// so that they can be replayed later, see Options.Journal and cmd/replay.
+// The file is rotated once it reaches MaxSize. Journals hold the messages
+// people sent, so they should be kept as safe as a database.
+type Journal struct {
+	// Path is the file the webhooks are appended to. Rotated files are kept
+	// next to it, with the time of the rotation appended to their name.
+	Path string
+	// MaxSize is the size, in bytes, from which the file is rotated. 0
+	// means DefaultJournalSize.
+	MaxSize int64
+	// MaxFiles is the number of rotated files kept. 0 keeps every file.
+	MaxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewJournal creates a Journal appending to the file at path.
+func NewJournal(path string) (*Journal, error) {
+	j := &Journal{Path: path}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Write appends e to the journal, rotating the file first if it is full.
+func (j *Journal) Write(e JournalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		if err := j.open(); err != nil {
+			return err
+		}
+	}
+
+	max := j.MaxSize
+	if max <= 0 {
+		max = DefaultJournalSize
+	}
+
+	if j.size > 0 && j.size+int64(len(data)) > max {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.f.Write(data)
+	j.size += int64(n)
+	return err
+}
+
+// Close closes the file of the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// open opens the file of the journal for appending.
+func (j *Journal) open() error {
+	f, err := os.OpenFile(j.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	j.f = f
+	j.size = info.Size()
+	return nil
+}
+
+// rotate moves the file of the journal aside, starts a new one and removes
+// the oldest rotated files beyond MaxFiles.
+func (j *Journal) rotate() error {
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	j.f = nil
+
+	rotated := j.Path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(j.Path, rotated); err != nil {
+		return err
+	}
+
+	if err := j.open(); err != nil {
+		return err
+	}
+
+	if j.MaxFiles <= 0 {
+		return nil
+	}
+
+	old, err := filepath.Glob(j.Path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(old)
+
+	for len(old) > j.MaxFiles {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+
+	return nil
+}
+
+// ReadJournal calls f with each entry of the journal r, in order, stopping at
+// the first error.
+func ReadJournal(r io.Reader, f func(JournalEntry) error) error {
+	br := bufio.NewReader(r)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e JournalEntry
+			if jerr := json.Unmarshal(line, &e); jerr != nil {
+				return jerr
+			}
+
+			if ferr := f(e); ferr != nil {
+				return ferr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// journalEntry returns the entry recording a webhook request.
+func journalEntry(r *http.Request, body []byte, received time.Time) JournalEntry {
+	header := r.Header.Clone()
+	for _, name := range journalHeaders {
+		header.Del(name)
+	}
+
+	return JournalEntry{
+		Time:   received,
+		Header: header,
+		Body:   string(body),
+	}
+}
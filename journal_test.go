@@ -0,0 +1,171 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.jsonl")
+
+	j, err := NewJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	e := JournalEntry{Time: time.Unix(1, 0).UTC(), Body: `{"object":"page","entry":[]}`}
+	line, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two entries fit in a file, so ten entries rotate it four times.
+	j.MaxSize = 2 * int64(len(line)+1)
+	j.MaxFiles = 2
+
+	for i := 0; i < 10; i++ {
+		if err := j.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != j.MaxFiles {
+		t.Errorf("%v rotated files kept, want %v: %v", len(rotated), j.MaxFiles, rotated)
+	}
+
+	for _, name := range append(rotated, path) {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != j.MaxSize {
+			t.Errorf("%v is %v bytes, want %v", name, info.Size(), j.MaxSize)
+		}
+	}
+}
+
+func TestJournalEntry(t *testing.T) {
+	body := `{"object":"page","entry":[]}`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Hub-Signature", "sha1=abc")
+
+	e := journalEntry(req, []byte(body), time.Unix(1, 0))
+	if e.Body != body {
+		t.Errorf("Body = %q, want %q", e.Body, body)
+	}
+	if got := e.Header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want it left out", got)
+	}
+	if got := e.Header.Get("X-Hub-Signature"); got != "sha1=abc" {
+		t.Errorf("X-Hub-Signature = %q, want sha1=abc", got)
+	}
+}
+
+// webhookJournal returns a journal of message webhooks signed with secret,
+// one from each of senders, received a second after one another.
+func webhookJournal(t *testing.T, secret string, senders ...int64) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "webhooks.jsonl")
+	j, err := NewJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, sender := range senders {
+		body := `{"object":"page","entry":[{"id":"1","time":1,"messaging":[{"sender":{"id":"` + strconv.FormatInt(sender, 10) +
+			`"},"recipient":{"id":"1"},"timestamp":1,"message":{"mid":"m` + strconv.Itoa(i) + `","text":"hi"}}]}]}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		signWebhook(req, secret, []byte(body))
+
+		if err := j.Write(journalEntry(req, []byte(body), time.Unix(int64(i+1), 0))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReplay(t *testing.T) {
+	// Webhooks are received at 1s, 2s, 3s and 4s.
+	journal := webhookJournal(t, "prod", 2, 3, 2, 4)
+
+	tests := []struct {
+		name    string
+		opts    ReplayOptions
+		secret  string
+		status  int
+		senders []int64
+	}{
+		{name: "all", secret: "prod", status: http.StatusOK, senders: []int64{2, 3, 2, 4}},
+		{name: "sender", opts: ReplayOptions{Sender: 2}, secret: "prod", status: http.StatusOK, senders: []int64{2, 2}},
+		{name: "window", opts: ReplayOptions{From: time.Unix(2, 0), To: time.Unix(3, 0)}, secret: "prod", status: http.StatusOK, senders: []int64{3, 2}},
+		{name: "sender and window", opts: ReplayOptions{Sender: 2, From: time.Unix(2, 0)}, secret: "prod", status: http.StatusOK, senders: []int64{2}},
+		{name: "recorded signature", secret: "staging", status: http.StatusForbidden, senders: []int64{2, 3, 2, 4}},
+		{name: "signed again", opts: ReplayOptions{AppSecret: "staging"}, secret: "staging", status: http.StatusOK, senders: []int64{2, 3, 2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Options{AppSecret: tt.secret, Logger: NopLogger})
+
+			var handled []int64
+			m.HandleMessage(func(msg Message, r *Response) {
+				handled = append(handled, msg.Sender.ID)
+			})
+
+			var replayed []int64
+			tt.opts.OnResult = func(e JournalEntry, status int) {
+				var rec Receive
+				if err := json.Unmarshal([]byte(e.Body), &rec); err != nil {
+					t.Fatal(err)
+				}
+				replayed = append(replayed, rec.Entry[0].Messaging[0].Sender.ID)
+
+				if status != tt.status {
+					t.Errorf("status = %v, want %v", status, tt.status)
+				}
+			}
+
+			n, err := Replay(context.Background(), strings.NewReader(journal), m.Handler(), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.senders) {
+				t.Errorf("Replay() = %v, want %v", n, len(tt.senders))
+			}
+			if !reflect.DeepEqual(replayed, tt.senders) {
+				t.Errorf("replayed webhooks from %v, want %v", replayed, tt.senders)
+			}
+
+			if tt.status != http.StatusOK {
+				tt.senders = nil
+			}
+			if !reflect.DeepEqual(handled, tt.senders) {
+				t.Errorf("handled messages from %v, want %v", handled, tt.senders)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package messenger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/url"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+const (
+	// LinkURL is the base of m.me links.
+	// Used in the form: https://m.me/<PAGE_USERNAME>?ref=<REF>
+	LinkURL = "https://m.me/"
+
+	// refSignatureSize is the number of bytes of the HMAC kept in a signed ref.
+	refSignatureSize = 16
+	// qrQuietZone is the number of blank modules around a QR code.
+	qrQuietZone = 4
+)
+
+// Link builds m.me links which open a conversation with a page. The ref of the
+// link is passed to the bot as a Referral, or on the PostBack of the Get
+// Started button.
+type Link struct {
+	// Page is the username or ID of the page.
+	Page string
+	// Secret is used to sign refs so that people cannot craft their own. It
+	// should be the same as Options.RefSecret. Refs are not signed if it is
+	// empty.
+	Secret []byte
+}
+
+// URL returns the m.me link passing ref to the bot.
+func (l Link) URL(ref string) string {
+	u := LinkURL + url.PathEscape(l.Page)
+	if ref == "" {
+		return u
+	}
+
+	if len(l.Secret) > 0 {
+		ref = SignRef(l.Secret, ref)
+	}
+
+	return u + "?ref=" + url.QueryEscape(ref)
+}
+
+// QR renders the link passing ref to the bot as a QR code, which can be sent
+// with Response.Image. Each module of the code is scale pixels wide.
+func (l Link) QR(ref string, scale int) (image.Image, error) {
+	code, err := qr.Encode(l.URL(ref), qr.M)
+	if err != nil {
+		return nil, err
+	}
+
+	if scale < 1 {
+		scale = 1
+	}
+
+	size := (code.Size + 2*qrQuietZone) * scale
+	im := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := color.Gray{Y: 0xFF}
+			if code.Black(x/scale-qrQuietZone, y/scale-qrQuietZone) {
+				c = color.Gray{Y: 0x00}
+			}
+			im.SetGray(x, y, c)
+		}
+	}
+
+	return im, nil
+}
+
+// WritePNG writes the QR code of the link passing ref to the bot to w as a
+// PNG, eg. for printing on flyers.
+func (l Link) WritePNG(w io.Writer, ref string, scale int) error {
+	im, err := l.QR(ref, scale)
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, im)
+}
+
+// SignRef appends an HMAC of ref, using secret, to ref.
+func SignRef(secret []byte, ref string) string {
+	return ref + "." + refSignature(secret, ref)
+}
+
+// VerifyRef checks the signature of a ref signed by SignRef, returning the ref
+// without its signature.
+func VerifyRef(secret []byte, signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return signed, false
+	}
+
+	ref, sig := signed[:i], signed[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(refSignature(secret, ref))) {
+		return signed, false
+	}
+
+	return ref, true
+}
+
+// refSignature returns the truncated and encoded HMAC of ref.
+func refSignature(secret []byte, ref string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ref))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:refSignatureSize])
+}
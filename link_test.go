@@ -0,0 +1,100 @@
+package messenger
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRef(t *testing.T) {
+	secret := []byte("secret")
+	signed := SignRef(secret, "promo.spring")
+
+	tests := []struct {
+		name   string
+		secret []byte
+		signed string
+		want   string
+		ok     bool
+	}{
+		{name: "signed", secret: secret, signed: signed, want: "promo.spring", ok: true},
+		{name: "wrong secret", secret: []byte("other"), signed: signed, want: signed},
+		{name: "tampered ref", secret: secret, signed: "promo.summer" + signed[len("promo.spring"):], want: "promo.summer" + signed[len("promo.spring"):]},
+		{name: "tampered signature", secret: secret, signed: signed[:len(signed)-1] + "A", want: signed[:len(signed)-1] + "A"},
+		{name: "unsigned", secret: secret, signed: "promo", want: "promo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := VerifyRef(tt.secret, tt.signed)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("VerifyRef(%q) = %q, %v, want %q, %v", tt.signed, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLinkURL(t *testing.T) {
+	l := Link{Page: "shop", Secret: []byte("secret")}
+
+	u, err := url.Parse(l.URL("promo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u.String(), LinkURL+"shop?") {
+		t.Errorf("URL() = %v, want a link to %vshop", u, LinkURL)
+	}
+	if ref, ok := VerifyRef(l.Secret, u.Query().Get("ref")); ref != "promo" || !ok {
+		t.Errorf("ref of URL() = %q, %v, want promo signed", ref, ok)
+	}
+}
+
+func TestVerifiedReferrals(t *testing.T) {
+	secret := []byte("secret")
+	signed := SignRef(secret, "promo")
+
+	tests := []struct {
+		name     string
+		secret   []byte
+		ref      string
+		want     string
+		verified bool
+	}{
+		{name: "signed", secret: secret, ref: signed, want: "promo", verified: true},
+		{name: "wrong secret", secret: []byte("other"), ref: signed, want: signed},
+		{name: "tampered", secret: secret, ref: "sale" + strings.TrimPrefix(signed, "promo"), want: "sale" + strings.TrimPrefix(signed, "promo")},
+		{name: "not signing", ref: signed, want: signed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Options{Logger: NopLogger, RefSecret: tt.secret})
+
+			var got []Referral
+			m.HandleReferral(func(r Referral, resp *Response) {
+				got = append(got, r)
+			})
+			m.HandlePostBack(func(p PostBack, resp *Response) {
+				if p.Referral == nil {
+					t.Fatalf("postback without its referral")
+				}
+				got = append(got, *p.Referral)
+			})
+
+			referral := `{"ref":"` + tt.ref + `","source":"SHORTLINK","type":"OPEN_THREAD"}`
+			postEvents(t, m, "messaging",
+				`"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":1,"referral":`+referral,
+				`"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":2,"postback":{"payload":"GET_STARTED","referral":`+referral+`}`,
+			)
+
+			if len(got) != 2 {
+				t.Fatalf("got %v referrals, want 2", len(got))
+			}
+			for i, r := range got {
+				if r.Ref != tt.want || r.Verified != tt.verified {
+					t.Errorf("referral %v: Ref = %q, Verified = %v, want %q, %v", i, r.Ref, r.Verified, tt.want, tt.verified)
+				}
+			}
+		})
+	}
+}
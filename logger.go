@@ -0,0 +1,69 @@
+package messenger
+
+import (
+	"log/slog"
+)
+
+// Logger receives the log lines of the Messenger. Each line is a message
+// followed by alternating keys and values, so a *slog.Logger can be used
+// directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger is a Logger which discards every line.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// NopLogger is a Logger which discards every line.
+var NopLogger Logger = nopLogger{}
+
+// defaultLogger returns the Logger used when Options.Logger is nil.
+func defaultLogger() Logger {
+	return slog.Default()
+}
+
+// eventFields returns the key-value pairs identifying e in a log line.
+func (m *Messenger) eventFields(e Event) []interface{} {
+	fields := []interface{}{
+		"page", e.PageID,
+		"sender", e.Sender.ID,
+		"mid", eventMid(e),
+		"type", string(e.Type),
+	}
+
+	if message, ok := e.Payload.(Message); ok {
+		fields = append(fields, "text", m.userText(message.Text))
+	}
+
+	return fields
+}
+
+// eventMid returns the ID of the message an event is about, if any.
+func eventMid(e Event) string {
+	switch p := e.Payload.(type) {
+	case Message:
+		return p.Mid
+	case Delivery:
+		if len(p.Mids) > 0 {
+			return p.Mids[0]
+		}
+	}
+	return ""
+}
+
+// userText returns text written by a person, for a log line. It is redacted
+// unless Options.LogUserData is set.
+func (m *Messenger) userText(text string) string {
+	if m.logUserData || text == "" {
+		return text
+	}
+	return redacted
+}
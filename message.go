@@ -1,6 +1,11 @@
 package messenger
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Message represents a Facebook messenge message.
 type Message struct {
@@ -10,6 +15,13 @@ type Message struct {
 	Recipient Recipient `json:"-"`
 	// Time is when the message was sent.
 	Time time.Time `json:"-"`
+	// IsEcho is whether the message is an echo of a message sent by the page.
+	IsEcho bool `json:"is_echo"`
+	// AppID is the ID of the app which sent an echoed message. Zero if the
+	// message was sent by a person managing the page.
+	AppID int64 `json:"app_id"`
+	// Metadata is the custom string sent along with an echoed message.
+	Metadata string `json:"metadata"`
 	// Mid is the ID of the message.
 	Mid string `json:"mid"`
 	// Seq is order the message was sent in relation to other messages.
@@ -19,6 +31,9 @@ type Message struct {
 	// Attachments is the information about the attachments which were sent
 	// with the message.
 	Attachments []Attachment `json:"attachments"`
+	// QuickReply is the quick reply which was tapped to send the message. Nil
+	// if the message was not sent from a quick reply.
+	QuickReply *QuickReply `json:"quick_reply"`
 }
 
 // Delivery represents a the event fired when Facebook delivers a message to the
@@ -52,6 +67,117 @@ type PostBack struct {
 	Time time.Time `json:"-"`
 	// PostBack ID
 	Payload string `json:"payload"`
+	// Referral is how the person got to the conversation, if they pressed the
+	// Get Started button after following a link or ad. Nil otherwise.
+	Referral *Referral `json:"referral"`
+}
+
+// Referral represents a person reaching the page through an m.me link, an ad
+// or the customer chat plugin.
+type Referral struct {
+	// Sender is who the message was sent from.
+	Sender Sender `json:"-"`
+	// Recipient is who the message was sent to.
+	Recipient Recipient `json:"-"`
+	// Time is when the message was sent.
+	Time time.Time `json:"-"`
+	// Ref is the ref parameter of the link or plugin.
+	Ref string `json:"ref"`
+	// Source is where the referral came from, eg. SHORTLINK, ADS or
+	// CUSTOMER_CHAT_PLUGIN.
+	Source string `json:"source"`
+	// Type is always OPEN_THREAD.
+	Type string `json:"type"`
+	// AdID is the ID of the ad, if the referral came from an ad.
+	AdID string `json:"ad_id"`
+	// RefererURI is the URI of the website, if the referral came from the
+	// customer chat plugin.
+	RefererURI string `json:"referer_uri"`
+	// Verified is whether Ref carried a valid signature, see Link. Only set
+	// when Options.RefSecret is set.
+	Verified bool `json:"-"`
+}
+
+// OptIn represents a person opting in through a "Send to Messenger" or checkbox
+// plugin.
+type OptIn struct {
+	// Sender is who the message was sent from. Empty for the checkbox plugin.
+	Sender Sender `json:"-"`
+	// Recipient is who the message was sent to.
+	Recipient Recipient `json:"-"`
+	// Time is when the message was sent.
+	Time time.Time `json:"-"`
+	// Ref is the data-ref parameter set on the plugin.
+	Ref string `json:"ref"`
+	// UserRef is the user_ref parameter of the checkbox plugin. It is used to
+	// send the first message, see Recipient.UserRef.
+	UserRef string `json:"user_ref"`
+	// Type is the type of opt in, if any.
+	Type string `json:"type"`
+}
+
+// AccountLinking represents a person linking or unlinking their account on
+// the website of the page.
+type AccountLinking struct {
+	// Sender is who the message was sent from.
+	Sender Sender `json:"-"`
+	// Recipient is who the message was sent to.
+	Recipient Recipient `json:"-"`
+	// Time is when the message was sent.
+	Time time.Time `json:"-"`
+	// Status is either linked or unlinked.
+	Status string `json:"status"`
+	// AuthorizationCode is the code passed back by the website through the
+	// redirect_uri. Only set when Status is linked.
+	AuthorizationCode string `json:"authorization_code"`
+}
+
+// Linked is whether the account was linked rather than unlinked.
+func (a AccountLinking) Linked() bool {
+	return a.Status == "linked"
+}
+
+// ThreadControl represents a change of the app which controls a conversation,
+// see the handover protocol.
+type ThreadControl struct {
+	// Sender is who the message was sent from.
+	Sender Sender `json:"-"`
+	// Recipient is who the message was sent to.
+	Recipient Recipient `json:"-"`
+	// Time is when the message was sent.
+	Time time.Time `json:"-"`
+	// NewOwnerAppID is the app control was passed to. Only set when control
+	// was passed.
+	NewOwnerAppID AppID `json:"new_owner_app_id"`
+	// PreviousOwnerAppID is the app control was taken from. Only set when
+	// control was taken.
+	PreviousOwnerAppID AppID `json:"previous_owner_app_id"`
+	// RequestedOwnerAppID is the app which requested control. Only set when
+	// control was requested.
+	RequestedOwnerAppID AppID `json:"requested_owner_app_id"`
+	// Metadata is the custom string sent along with the change.
+	Metadata string `json:"metadata"`
+}
+
+// AppID is the ID of an app. Facebook sends app IDs as strings in some events
+// and as numbers in others, so both are accepted.
+type AppID int64
+
+// UnmarshalJSON decodes an app ID sent as a string or a number.
+func (id *AppID) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*id = 0
+		return nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("messenger: invalid app ID %s", data)
+	}
+
+	*id = AppID(n)
+	return nil
 }
 
 // Watermark is the RawWatermark timestamp rendered as a time.Time.
@@ -1,9 +1,11 @@
 package messenger
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -16,13 +18,89 @@ const (
 // Options are the settings used when creating a Messenger client.
 type Options struct {
 	// Verify sets whether or not to be in the "verify" mode. Used for
-	// verifying webhooks on the Facebook Developer Portal.
+	// verifying webhooks on the Facebook Developer Portal. Outside of verify
+	// mode, verification requests are rejected unless allowed with
+	// AllowVerify.
 	Verify bool
 	// VerifyToken is the token to be used when verifying the webhook. Is set
-	// when the webhook is created.
+	// when the webhook is created. Verification always fails when it is
+	// empty.
 	VerifyToken string
 	// Token is the access token of the Facebook page to send messages from.
+	// When PageTokens is set, it is the token of the default page.
 	Token string
+	// TokenProvider supplies the token of the default page instead of Token,
+	// eg. from a file or a secrets store, so that it can be rotated.
+	TokenProvider TokenProvider
+	// AppSecret is the secret of the Facebook app. When set, an
+	// appsecret_proof is sent with every Graph API request, and webhooks
+	// without a valid X-Hub-Signature are rejected.
+	AppSecret string
+	// PageTokens looks up the access tokens of the pages the Messenger
+	// serves. Responses to events use the token of the page the event was
+	// sent to. Leaving it nil means Token is used for every page.
+	PageTokens PageTokenProvider
+	// RefSecret is the secret used to sign the refs of links, see Link. When
+	// set, the refs of incoming referrals are checked and stripped of their
+	// signature.
+	RefSecret []byte
+	// HTTPClient is used for Graph API requests. Leaving it nil uses a client
+	// with a timeout of DefaultTimeout.
+	HTTPClient *http.Client
+	// Retry decides how failed Graph API requests, including sends, are
+	// retried. Leaving it empty means requests are not retried, see
+	// DefaultRetryPolicy.
+	Retry RetryPolicy
+	// ThrottleAt is the usage percentage, as reported by Facebook, above
+	// which Graph API requests are slowed down to avoid being blocked.
+	// Requests which would be held for more than a few seconds, eg. while
+	// Facebook blocks the app, fail with a *ThrottledError instead. 0 means
+	// DefaultThrottleAt and a negative value disables throttling.
+	ThrottleAt int
+	// Breaker configures a circuit breaker around the Graph API, which fails
+	// requests with a *CircuitOpenError while Facebook is down instead of
+	// waiting for each of them to time out. It is disabled by default.
+	Breaker BreakerOptions
+	// Logger receives the log lines of the Messenger. Lines about an event
+	// carry its page, sender and message ID, and lines about a message sent
+	// in reply, directly or through the Outbox, carry its page, recipient and
+	// the ID of the message it replies to. Leaving it nil logs to
+	// slog.Default, and NopLogger discards the lines.
+	Logger Logger
+	// LogUserData sets whether text written by people is included in log
+	// lines. It is redacted by default.
+	LogUserData bool
+	// Metrics receives counters and latencies of webhook events, handlers
+	// and Graph API requests, eg. a Registry. Leaving it nil records nothing.
+	Metrics Metrics
+	// MaxBodySize is the largest webhook body accepted, in bytes. 0 means
+	// DefaultMaxBodySize.
+	MaxBodySize int64
+	// Journal, if not nil, records the raw body and headers of every webhook
+	// which passes the signature check, so that it can be replayed.
+	Journal *Journal
+	// Downstreams are apps every webhook which passes the signature check is
+	// forwarded to, eg. an analytics service, while the Messenger handles it
+	// as usual. Forwards are sent in the background and retried on failure.
+	// Webhooks are only forwarded when AppSecret is set, so that forged
+	// webhooks are never signed and passed on.
+	Downstreams []Downstream
+	// OnError, if not nil, is called for each webhook request which is
+	// rejected, eg. because of a bad signature.
+	OnError ErrorHandler
+	// Tracer, if not nil, traces the receipt of each webhook, the handling of
+	// each event and each Graph API request. Messages sent with a Response
+	// are traced as children of the handler span.
+	Tracer Tracer
+	// Outbox, when set, queues every message sent with a Response in the
+	// store, and sends them in the background. Messages to the same recipient
+	// are sent in order, and messages which cannot be sent are moved to a
+	// dead-letter list, see DeadLetters. Call Shutdown to wait for the queued
+	// messages to be sent before exiting.
+	Outbox OutboxStore
+	// IncludeEchoes sets whether echoes of messages sent by the page are
+	// passed to the MessageHandlers as well as the EchoHandlers.
+	IncludeEchoes bool
 	// WebhookURL is where the Messenger client should listen for webhook events. Leaving the string blank implies a path of "/".
 	WebhookURL string
 }
@@ -30,6 +108,26 @@ type Options struct {
 // MessageHandler is a handler used for responding to a message containing text.
 type MessageHandler func(Message, *Response)
 
+// EchoHandler is a handler used for responding to an echo of a message sent
+// by the page.
+type EchoHandler func(Message, *Response)
+
+// OptInHandler is a handler used for responding to a person opting in through
+// a plugin.
+type OptInHandler func(OptIn, *Response)
+
+// ReferralHandler is a handler used for responding to a person following a
+// link, ad or plugin into an existing conversation.
+type ReferralHandler func(Referral, *Response)
+
+// AccountLinkingHandler is a handler used for responding to a person linking
+// or unlinking their account.
+type AccountLinkingHandler func(AccountLinking, *Response)
+
+// ThreadControlHandler is a handler used for responding to a change of the
+// app which controls a conversation.
+type ThreadControlHandler func(ThreadControl, *Response)
+
 // DeliveryHandler is a handler used for responding to a delivery receipt.
 type DeliveryHandler func(Delivery, *Response)
 
@@ -41,27 +139,119 @@ type PostBackHandler func(PostBack, *Response)
 
 // Messenger is the client which manages communication with the Messenger Platform API.
 type Messenger struct {
-	mux              *http.ServeMux
-	messageHandlers  []MessageHandler
-	deliveryHandlers []DeliveryHandler
-	readHandlers     []ReadHandler
-	postBackHandlers []PostBackHandler
-	token            string
-	verifyHandler    func(http.ResponseWriter, *http.Request)
+	mux         *http.ServeMux
+	kinds       []eventKind
+	handlers    map[EventType][]EventHandler
+	standby     []EventHandler
+	payloads    payloadRouter
+	texts       textRouter
+	tokens      TokenProvider
+	appSecret   string
+	pages       PageTokenProvider
+	client      *http.Client
+	retry       RetryPolicy
+	usage       *usage
+	breaker     *breaker
+	log         Logger
+	logUserData bool
+	metrics     Metrics
+	tracer      Tracer
+	outbox      *outbox
+	refSecret   []byte
+	verifyToken string
+	verifyMode  bool
+	verifyState verifyState
+	maxBodySize int64
+	onError     ErrorHandler
+	journal     *Journal
+	forwarder   *forwarder
+	// includeEchoes passes EchoEvents to the handlers of MessageEvents too,
+	// see Options.IncludeEchoes.
+	includeEchoes bool
 }
 
 // New creates a new Messenger. You pass in Options in order to affect settings.
 func New(mo Options) *Messenger {
 	m := &Messenger{
-		mux:   http.NewServeMux(),
-		token: mo.Token,
+		mux:         http.NewServeMux(),
+		tokens:      mo.TokenProvider,
+		appSecret:   mo.AppSecret,
+		pages:       mo.PageTokens,
+		client:      mo.HTTPClient,
+		retry:       mo.Retry,
+		usage:       &usage{throttleAt: mo.ThrottleAt},
+		breaker:     newBreaker(mo.Breaker),
+		log:         mo.Logger,
+		logUserData: mo.LogUserData,
+		metrics:     mo.Metrics,
+		tracer:      mo.Tracer,
+		verifyToken: mo.VerifyToken,
+		verifyMode:  mo.Verify,
+		maxBodySize: mo.MaxBodySize,
+		onError:     mo.OnError,
+		journal:     mo.Journal,
+		refSecret:   mo.RefSecret,
+
+		includeEchoes: mo.IncludeEchoes,
+	}
+
+	if m.tokens == nil && mo.Token != "" {
+		m.tokens = StaticToken(mo.Token)
+	}
+
+	if m.log == nil {
+		m.log = defaultLogger()
+	}
+
+	if m.metrics == nil {
+		m.metrics = nopMetrics{}
+	}
+
+	if m.tracer == nil {
+		m.tracer = nopTracer{}
+	}
+
+	if m.maxBodySize <= 0 {
+		m.maxBodySize = DefaultMaxBodySize
+	}
+
+	if m.client == nil {
+		m.client = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	switch {
+	case len(mo.Downstreams) > 0 && m.appSecret == "":
+		m.log.Error("not forwarding webhooks to downstreams without an app secret to verify them")
+	case len(mo.Downstreams) > 0:
+		m.forwarder = newForwarder(m, mo.Downstreams)
+	}
+
+	if m.usage.throttleAt == 0 {
+		m.usage.throttleAt = DefaultThrottleAt
+	}
+
+	if mo.Outbox != nil {
+		m.outbox = newOutbox(m, mo.Outbox)
 	}
 
 	if mo.WebhookURL == "" {
 		mo.WebhookURL = "/"
 	}
 
-	m.verifyHandler = newVerifyHandler(mo.VerifyToken)
+	m.kinds = []eventKind{
+		{typ: EchoEvent, field: "message", decode: decodeEcho, replyTo: replyToRecipient},
+		{typ: MessageEvent, field: "message", decode: decodeMessage, route: m.routeMessage},
+		{typ: DeliveryEvent, field: "delivery", decode: decodeDelivery},
+		{typ: ReadEvent, field: "read", decode: decodeRead},
+		{typ: PostBackEvent, field: "postback", decode: m.decodePostBack, route: m.routePostBack},
+		{typ: OptInEvent, field: "optin", decode: decodeOptIn, replyTo: replyToOptIn},
+		{typ: ReferralEvent, field: "referral", decode: m.decodeReferral},
+		{typ: AccountLinkingEvent, field: "account_linking", decode: decodeAccountLinking},
+		{typ: PassThreadControlEvent, field: "pass_thread_control", decode: decodeThreadControl},
+		{typ: TakeThreadControlEvent, field: "take_thread_control", decode: decodeThreadControl},
+		{typ: RequestThreadControlEvent, field: "request_thread_control", decode: decodeThreadControl},
+	}
+
 	m.mux.HandleFunc(mo.WebhookURL, m.handle)
 
 	return m
@@ -70,24 +260,112 @@ func New(mo Options) *Messenger {
 // HandleMessage adds a new MessageHandler to the Messenger which will be triggered
 // when a message is received by the client.
 func (m *Messenger) HandleMessage(f MessageHandler) {
-	m.messageHandlers = append(m.messageHandlers, f)
+	m.Handle(MessageEvent, func(e Event, r *Response) {
+		f(e.Payload.(Message), r)
+	})
+}
+
+// HandleEcho adds a new EchoHandler to the Messenger which will be triggered
+// when a message is sent by the page. Echoes of messages sent by a person
+// managing the page have no AppID.
+func (m *Messenger) HandleEcho(f EchoHandler) {
+	m.Handle(EchoEvent, func(e Event, r *Response) {
+		f(e.Payload.(Message), r)
+	})
 }
 
 // HandleDelivery adds a new DeliveryHandler to the Messenger which will be triggered
 // when a previously sent message is delivered to the recipient.
 func (m *Messenger) HandleDelivery(f DeliveryHandler) {
-	m.deliveryHandlers = append(m.deliveryHandlers, f)
+	m.Handle(DeliveryEvent, func(e Event, r *Response) {
+		f(e.Payload.(Delivery), r)
+	})
 }
 
 // HandleRead adds a new DeliveryHandler to the Messenger which will be triggered
 // when a previously sent message is read by the recipient.
 func (m *Messenger) HandleRead(f ReadHandler) {
-	m.readHandlers = append(m.readHandlers, f)
+	m.Handle(ReadEvent, func(e Event, r *Response) {
+		f(e.Payload.(Read), r)
+	})
 }
 
 // HandlePostBack adds a new PostBackHandler to the Messenger
 func (m *Messenger) HandlePostBack(f PostBackHandler) {
-	m.postBackHandlers = append(m.postBackHandlers, f)
+	m.Handle(PostBackEvent, func(e Event, r *Response) {
+		f(e.Payload.(PostBack), r)
+	})
+}
+
+// HandleOptIn adds a new OptInHandler to the Messenger which will be triggered
+// when a person opts in through a "Send to Messenger" or checkbox plugin.
+func (m *Messenger) HandleOptIn(f OptInHandler) {
+	m.Handle(OptInEvent, func(e Event, r *Response) {
+		f(e.Payload.(OptIn), r)
+	})
+}
+
+// HandleReferral adds a new ReferralHandler to the Messenger which will be
+// triggered when a person already in a conversation with the page follows an
+// m.me link, an ad or the customer chat plugin. New conversations carry the
+// referral on the PostBack of the Get Started button instead.
+func (m *Messenger) HandleReferral(f ReferralHandler) {
+	m.Handle(ReferralEvent, func(e Event, r *Response) {
+		f(e.Payload.(Referral), r)
+	})
+}
+
+// HandleAccountLinking adds a new AccountLinkingHandler to the Messenger which
+// will be triggered when a person links or unlinks their account through a
+// log in or log out button.
+func (m *Messenger) HandleAccountLinking(f AccountLinkingHandler) {
+	m.Handle(AccountLinkingEvent, func(e Event, r *Response) {
+		f(e.Payload.(AccountLinking), r)
+	})
+}
+
+// HandlePassThreadControl adds a new ThreadControlHandler to the Messenger
+// which will be triggered when control of a conversation is passed to the app.
+func (m *Messenger) HandlePassThreadControl(f ThreadControlHandler) {
+	m.Handle(PassThreadControlEvent, func(e Event, r *Response) {
+		f(e.Payload.(ThreadControl), r)
+	})
+}
+
+// HandleTakeThreadControl adds a new ThreadControlHandler to the Messenger
+// which will be triggered when control of a conversation is taken from the
+// app.
+func (m *Messenger) HandleTakeThreadControl(f ThreadControlHandler) {
+	m.Handle(TakeThreadControlEvent, func(e Event, r *Response) {
+		f(e.Payload.(ThreadControl), r)
+	})
+}
+
+// HandleRequestThreadControl adds a new ThreadControlHandler to the Messenger
+// which will be triggered when another app asks for control of a conversation.
+func (m *Messenger) HandleRequestThreadControl(f ThreadControlHandler) {
+	m.Handle(RequestThreadControlEvent, func(e Event, r *Response) {
+		f(e.Payload.(ThreadControl), r)
+	})
+}
+
+// HandleStandby adds an EventHandler to the Messenger which will be triggered
+// when an event of any type is received while another app controls the
+// conversation. The Response is passive, so a secondary app can log or analyse
+// the conversation without sending any messages.
+func (m *Messenger) HandleStandby(f EventHandler) {
+	m.standby = append(m.standby, f)
+}
+
+// ResponseTo creates a Response for sending messages from page to a recipient
+// outside of a handler, eg. continuing a checkout with the user_ref of a
+// checkbox plugin. A page of 0 means the default page.
+func (m *Messenger) ResponseTo(page int64, to Recipient) *Response {
+	return &Response{
+		m:    m,
+		page: page,
+		to:   to,
+	}
 }
 
 // Handler returns the Messenger in HTTP client form.
@@ -95,118 +373,203 @@ func (m *Messenger) Handler() http.Handler {
 	return m.mux
 }
 
-// ProfileByID retrieves the Facebook user associated with that ID
-func (m *Messenger) ProfileByID(id int64) (Profile, error) {
+// ProfileByID retrieves the Facebook user associated with that ID, as seen by
+// page. IDs are scoped to a page, so page must be the page the person wrote
+// to, eg. Response.PageID. A page of 0 means the default page.
+func (m *Messenger) ProfileByID(ctx context.Context, page, id int64) (Profile, error) {
 	p := Profile{}
-	url := fmt.Sprintf("%v%v", ProfileURL, id)
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return p, err
-	}
+	query := url.Values{}
+	query.Set("fields", "first_name,last_name,profile_pic")
 
-	req.URL.RawQuery = "fields=first_name,last_name,profile_pic&access_token=" + m.token
+	err := m.graph(ctx, page, "GET", strconv.FormatInt(id, 10), query, nil, &p)
+	return p, err
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
+// PSIDByLinkingToken retrieves the ID of the person who pressed a log in
+// button of page, using the account_linking_token passed to the website. A
+// page of 0 means the default page.
+func (m *Messenger) PSIDByLinkingToken(ctx context.Context, page int64, token string) (int64, error) {
+	var res struct {
+		Recipient int64 `json:"recipient,string"`
+	}
 
-	err = json.NewDecoder(resp.Body).Decode(&p)
+	query := url.Values{}
+	query.Set("fields", "recipient")
+	query.Set("account_linking_token", token)
 
-	return p, err
+	err := m.graph(ctx, page, "GET", "me", query, nil, &res)
+	return res.Recipient, err
 }
 
 // handle is the internal HTTP handler for the webhooks.
 func (m *Messenger) handle(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "GET" {
-		m.verifyHandler(w, r)
+	switch r.Method {
+	case "GET":
+		m.verify(w, r)
+		return
+	case "POST":
+	default:
+		m.reject(w, r, http.StatusMethodNotAllowed, ErrMethod)
+		return
+	}
+
+	received := time.Now()
+
+	// Handlers may reply after the webhook request is done, eg. from a
+	// goroutine, so its cancellation is not passed on to their Responses.
+	ctx, span := m.tracer.Start(context.WithoutCancel(r.Context()), "messenger.webhook")
+	defer span.End()
+
+	body, status, err := m.readWebhook(w, r)
+	if err != nil {
+		span.RecordError(err)
+		m.reject(w, r, status, err)
 		return
 	}
 
+	if m.journal != nil {
+		if err := m.journal.Write(journalEntry(r, body, received)); err != nil {
+			m.log.Error("could not write journal", "error", err, "remote", r.RemoteAddr)
+		}
+	}
+
 	var rec Receive
 
-	err := json.NewDecoder(r.Body).Decode(&rec)
+	err = json.Unmarshal(body, &rec)
 	if err != nil {
-		fmt.Println("could not decode response:", err)
-		fmt.Fprintln(w, `{status: 'not ok'}`)
+		span.RecordError(err)
+		m.metrics.Count(MetricDecodeFailures, nil)
+		m.reject(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	if rec.Object != "page" {
-		fmt.Println("Object is not page, undefined behaviour. Got", rec.Object)
+		m.log.Warn("webhook object is not page", "object", rec.Object, "remote", r.RemoteAddr)
+	}
+
+	if m.forwarder != nil {
+		m.forwarder.forward(body)
 	}
 
-	m.dispatch(rec)
+	m.dispatch(ctx, rec)
 
-	fmt.Fprintln(w, `{status: 'ok'}`)
+	writeStatus(w, http.StatusOK, "")
 }
 
 // dispatch triggers all of the relevant handlers when a webhook event is received.
-func (m *Messenger) dispatch(r Receive) {
+// Standby events are only passed to the standby handlers, so that the Messenger
+// stays quiet while another app, such as the Page Inbox, controls the
+// conversation.
+func (m *Messenger) dispatch(ctx context.Context, r Receive) {
 	for _, entry := range r.Entry {
 		for _, info := range entry.Messaging {
-			a := m.classify(info, entry)
-			if a == UnknownAction {
-				fmt.Println("Unknown action:", info)
-				continue
-			}
-
-			resp := &Response{
-				to:    Recipient{info.Sender.ID},
-				token: m.token,
-			}
-
-			switch a {
-			case TextAction:
-				for _, f := range m.messageHandlers {
-					message := *info.Message
-					message.Sender = info.Sender
-					message.Recipient = info.Recipient
-					message.Time = time.Unix(info.Timestamp, 0)
-					f(message, resp)
-				}
-			case DeliveryAction:
-				for _, f := range m.deliveryHandlers {
-					f(*info.Delivery, resp)
-				}
-			case ReadAction:
-				for _, f := range m.readHandlers {
-					f(*info.Read, resp)
-				}
-			case PostBackAction:
-				for _, f := range m.postBackHandlers {
-					message := *info.PostBack
-					message.Sender = info.Sender
-					message.Recipient = info.Recipient
-					message.Time = time.Unix(info.Timestamp, 0)
-					f(message, resp)
-				}
-			}
+			m.dispatchEvent(ctx, info, entry, false)
+		}
+
+		for _, info := range entry.Standby {
+			m.dispatchEvent(ctx, info, entry, true)
 		}
 	}
 }
 
-// classify determines what type of message a webhook event is.
-func (m *Messenger) classify(info MessageInfo, e Entry) Action {
-	if info.Message != nil {
-		return TextAction
-	} else if info.Delivery != nil {
-		return DeliveryAction
-	} else if info.Read != nil {
-		return ReadAction
-	} else if info.PostBack != nil {
-		return PostBackAction
+// dispatchEvent triggers the relevant handlers for a single event, each in a
+// span which is a child of the span of the event.
+func (m *Messenger) dispatchEvent(ctx context.Context, info MessageInfo, entry Entry, standby bool) {
+	e, k, err := m.decodeEvent(info, entry)
+
+	ctx, span := m.tracer.Start(ctx, "messenger.event", eventAttributes(e)...)
+	defer span.End()
+
+	if err != nil {
+		span.RecordError(err)
+		m.log.Warn("could not decode event", append(m.eventFields(e), "error", err)...)
+		m.metrics.Count(MetricDecodeFailures, nil)
+		return
 	}
-	return UnknownAction
-}
+	e.Standby = standby
+
+	m.metrics.Count(MetricEvents, map[string]string{"type": string(e.Type)})
 
-// newVerifyHandler returns a function which can be used to handle webhook verification
-func newVerifyHandler(token string) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.FormValue("hub.verify_token") == token {
-			fmt.Fprintln(w, r.FormValue("hub.challenge"))
+	if k == nil {
+		m.log.Debug("unknown event", m.eventFields(e)...)
+	} else {
+		m.log.Debug("received event", append(m.eventFields(e), "standby", standby)...)
+	}
+
+	to := Recipient{ID: e.Sender.ID}
+	if k != nil && k.replyTo != nil {
+		to = k.replyTo(e)
+	}
+
+	resp := &Response{
+		m:       m,
+		ctx:     ctx,
+		page:    e.PageID,
+		to:      to,
+		mid:     eventMid(e),
+		passive: standby,
+	}
+
+	start := time.Now()
+	defer func() {
+		m.metrics.Observe(MetricHandlerSeconds, map[string]string{"type": string(e.Type)}, since(start))
+	}()
+
+	if standby {
+		for _, f := range m.standby {
+			m.traced("messenger.handler", e, resp, func(r *Response) {
+				f(e, r)
+			})
+		}
+		return
+	}
+
+	if k != nil && k.route != nil {
+		routed := false
+		m.traced("messenger.route", e, resp, func(r *Response) {
+			routed = k.route(e, r)
+		})
+
+		if routed {
 			return
 		}
-		fmt.Fprintln(w, "Incorrect verify token.")
 	}
+
+	handlers := m.handlers[e.Type]
+	if e.Type == EchoEvent && m.includeEchoes {
+		handlers = append(append([]EventHandler(nil), m.handlers[MessageEvent]...), handlers...)
+	}
+
+	for _, f := range handlers {
+		m.traced("messenger.handler", e, resp, func(r *Response) {
+			f(e, r)
+		})
+	}
+}
+
+// routeMessage passes a MessageEvent through the payload routes if it was
+// sent from a quick reply, then through the text router. The default of the
+// payload router is left to postbacks.
+func (m *Messenger) routeMessage(e Event, r *Response) bool {
+	message := e.Payload.(Message)
+
+	if message.QuickReply != nil {
+		p := PostBack{
+			Sender:    message.Sender,
+			Recipient: message.Recipient,
+			Time:      message.Time,
+			Payload:   message.QuickReply.Payload,
+		}
+		if m.payloads.route(p, r, false) {
+			return true
+		}
+	}
+
+	return m.texts.route(message, r)
+}
+
+// routePostBack passes a PostBackEvent through the payload router.
+func (m *Messenger) routePostBack(e Event, r *Response) bool {
+	return m.payloads.route(e.Payload.(PostBack), r, true)
 }
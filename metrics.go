@@ -0,0 +1,270 @@
+package messenger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the metrics recorded by the Messenger.
+const (
+	// MetricEvents counts the webhook events received, by type. Events which
+	// could not be classified have the type "unknown".
+	MetricEvents = "messenger_events_total"
+	// MetricDecodeFailures counts the webhooks and events which could not be
+	// decoded.
+	MetricDecodeFailures = "messenger_decode_failures_total"
+	// MetricHandlerSeconds is the time taken by the routes and handlers of
+	// each event, by event type.
+	MetricHandlerSeconds = "messenger_handler_seconds"
+	// MetricSendSeconds is the time taken to send a message through the Send
+	// API, including retries.
+	MetricSendSeconds = "messenger_send_seconds"
+	// MetricGraphErrors counts the failed Graph API requests, by error code.
+	// Errors which did not come from Facebook have the code "network",
+	// "circuit_open" or "throttled".
+	MetricGraphErrors = "messenger_graph_errors_total"
+	// MetricFanOutErrors counts the failed forwards of webhooks, by
+	// downstream.
+	MetricFanOutErrors = "messenger_fanout_errors_total"
+	// MetricVerifyFailures counts the failed webhook verification requests.
+	MetricVerifyFailures = "messenger_verify_failures_total"
+	// MetricRetries counts the Graph API requests which were retried.
+	MetricRetries = "messenger_graph_retries_total"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram buckets of
+// a Registry.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics receives the measurements of the Messenger, see Options.Metrics.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Count increments the counter name with labels.
+	Count(name string, labels map[string]string)
+	// Observe records value in the histogram name with labels.
+	Observe(name string, labels map[string]string, value float64)
+}
+
+// nopMetrics is the Metrics used when Options.Metrics is nil.
+type nopMetrics struct{}
+
+func (nopMetrics) Count(string, map[string]string)            {}
+func (nopMetrics) Observe(string, map[string]string, float64) {}
+
+// graphErrorCode returns the label of the error code of err for
+// MetricGraphErrors.
+func graphErrorCode(err error) string {
+	var ge *Error
+	if errors.As(err, &ge) {
+		return strconv.Itoa(ge.Code)
+	}
+	if _, ok := isCircuitOpen(err); ok {
+		return "circuit_open"
+	}
+	if _, ok := isThrottled(err); ok {
+		return "throttled"
+	}
+	return "network"
+}
+
+// since returns the seconds elapsed since start.
+func since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Registry is a Metrics which keeps the measurements in memory. It serves them
+// over HTTP in the Prometheus text format, and can be published with expvar,
+// eg. expvar.Publish("messenger", registry).
+type Registry struct {
+	buckets []float64
+
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+// histogram is a single series of a histogram.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewRegistry creates an empty Registry with histograms using buckets, or
+// DefaultBuckets if none are given.
+func NewRegistry(buckets ...float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Registry{
+		buckets:    buckets,
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*histogram{},
+	}
+}
+
+// Count implements Metrics.
+func (r *Registry) Count(name string, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	series, ok := r.counters[name]
+	if !ok {
+		series = map[string]float64{}
+		r.counters[name] = series
+	}
+	series[formatLabels(labels)]++
+}
+
+// Observe implements Metrics.
+func (r *Registry) Observe(name string, labels map[string]string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	series, ok := r.histograms[name]
+	if !ok {
+		series = map[string]*histogram{}
+		r.histograms[name] = series
+	}
+
+	key := formatLabels(labels)
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets))}
+		series[key] = h
+	}
+
+	for i, le := range r.buckets {
+		if value <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// ServeHTTP writes the measurements in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	var names []string
+	for name := range r.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %v counter\n", name)
+
+		series := r.counters[name]
+		keys := make([]string, 0, len(series))
+		for labels := range series {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+
+		for _, labels := range keys {
+			fmt.Fprintf(w, "%v%v %v\n", name, labels, formatValue(series[labels]))
+		}
+	}
+
+	names = names[:0]
+	for name := range r.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %v histogram\n", name)
+
+		series := r.histograms[name]
+		keys := make([]string, 0, len(series))
+		for labels := range series {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+
+		for _, labels := range keys {
+			h := series[labels]
+			for i, le := range r.buckets {
+				fmt.Fprintf(w, "%v_bucket%v %v\n", name, withLabel(labels, "le", formatValue(le)), h.counts[i])
+			}
+			fmt.Fprintf(w, "%v_bucket%v %v\n", name, withLabel(labels, "le", "+Inf"), h.count)
+			fmt.Fprintf(w, "%v_sum%v %v\n", name, labels, formatValue(h.sum))
+			fmt.Fprintf(w, "%v_count%v %v\n", name, labels, h.count)
+		}
+	}
+}
+
+// String returns the measurements as JSON, so that a Registry is an
+// expvar.Var. Histograms are reduced to their count and sum.
+func (r *Registry) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type summary struct {
+		Count uint64  `json:"count"`
+		Sum   float64 `json:"sum"`
+	}
+
+	vars := map[string]interface{}{}
+	for name, series := range r.counters {
+		for labels, v := range series {
+			vars[name+labels] = v
+		}
+	}
+	for name, series := range r.histograms {
+		for labels, h := range series {
+			vars[name+labels] = summary{Count: h.count, Sum: h.sum}
+		}
+	}
+
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// formatLabels formats labels in the Prometheus text format, in order of name.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", name, strconv.Quote(value)))
+	}
+	sort.Strings(pairs)
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to labels formatted by formatLabels.
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf("%v=%v", name, strconv.Quote(value))
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// formatValue formats v in the Prometheus text format.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
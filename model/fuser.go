@@ -6,4 +6,7 @@ type FUser struct {
 	FID       int64  `json:"fid" gorm:"column:fid"`
 	Firstname string `json:"firstname"`
 	Lastname  string `json:"lastname"`
+	// AccountID is the ID of the customer on the website, set once the user
+	// has linked their account.
+	AccountID string `json:"account_id" gorm:"column:account_id"`
 }
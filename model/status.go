@@ -1,5 +1,13 @@
 package model
 
+import (
+	"strconv"
+	"strings"
+)
+
+// statusMetadataPrefix prefixes the status in message metadata.
+const statusMetadataPrefix = "status:"
+
 const (
 	StatusGreeting = iota + 1
 	StatusGetLink
@@ -12,3 +20,22 @@ const (
 	StatusCheckOrder
 	StatusCancelOrder
 )
+
+// StatusMetadata returns the metadata to send with a prompt for status, so
+// that its echo can be matched with the user session.
+func StatusMetadata(status int) string {
+	return statusMetadataPrefix + strconv.Itoa(status)
+}
+
+// ParseStatusMetadata returns the status stored in metadata by StatusMetadata.
+func ParseStatusMetadata(metadata string) (int, bool) {
+	if !strings.HasPrefix(metadata, statusMetadataPrefix) {
+		return 0, false
+	}
+
+	status, err := strconv.Atoi(strings.TrimPrefix(metadata, statusMetadataPrefix))
+	if err != nil {
+		return 0, false
+	}
+	return status, true
+}
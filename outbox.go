@@ -0,0 +1,459 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// outboxPoll is how often the outbox checks its store for messages which
+	// are ready to be sent.
+	outboxPoll = time.Second
+	// outboxDrainPoll is how often Shutdown checks whether the outbox is empty.
+	outboxDrainPoll = 100 * time.Millisecond
+)
+
+// outboxRetry decides how often, and how quickly, failed sends are retried by
+// the outbox before they are moved to the dead-letter list. The outbox
+// delivers messages at least once, so sends which may have reached Facebook
+// are retried too.
+var outboxRetry = RetryPolicy{
+	Attempts:    10,
+	BaseDelay:   time.Second,
+	MaxDelay:    5 * time.Minute,
+	RetryUnsafe: true,
+}
+
+// OutboxMessage is a send waiting in the outbox, or one which failed
+// permanently and was moved to the dead-letter list.
+type OutboxMessage struct {
+	// ID identifies the message. IDs sort in the order messages were queued.
+	ID string `json:"id"`
+	// Page is the ID of the page the message is sent from.
+	Page int64 `json:"page"`
+	// Recipient is who the message is sent to.
+	Recipient Recipient `json:"recipient"`
+	// ReplyTo is the ID of the message the Response which queued the message
+	// was created for, if any.
+	ReplyTo string `json:"reply_to,omitempty"`
+	// ContentType is the content type of Body.
+	ContentType string `json:"content_type"`
+	// Body is the request sent to the Send API.
+	Body []byte `json:"body"`
+	// Attempts is how many times sending the message has failed.
+	Attempts int `json:"attempts"`
+	// LastError is the error of the last failed attempt.
+	LastError string `json:"last_error,omitempty"`
+	// Created is when the message was queued.
+	Created time.Time `json:"created"`
+}
+
+// lane is the key of the queue of messages for a single recipient, which are
+// sent one at a time and in order.
+func (msg OutboxMessage) lane() string {
+	if msg.Recipient.UserRef != "" {
+		return fmt.Sprintf("%v/ref:%v", msg.Page, msg.Recipient.UserRef)
+	}
+	return fmt.Sprintf("%v/id:%v", msg.Page, msg.Recipient.ID)
+}
+
+// fields returns the key-value pairs identifying msg in a log line.
+func (msg OutboxMessage) fields() []interface{} {
+	return []interface{}{
+		"page", msg.Page,
+		"recipient", msg.Recipient.ID,
+		"mid", msg.ReplyTo,
+		"id", msg.ID,
+		"attempts", msg.Attempts,
+	}
+}
+
+// OutboxStore persists the messages of the outbox, see Options.Outbox.
+// Implementations must be safe for concurrent use.
+type OutboxStore interface {
+	// Put adds msg to the queue, or replaces the queued message with the same
+	// ID.
+	Put(msg OutboxMessage) error
+	// Pending returns the queued messages in order of ID.
+	Pending() ([]OutboxMessage, error)
+	// Delete removes a message from the queue.
+	Delete(id string) error
+	// PutDead adds msg to the dead-letter list.
+	PutDead(msg OutboxMessage) error
+	// Dead returns the dead-letter list in order of ID.
+	Dead() ([]OutboxMessage, error)
+	// DeleteDead removes a message from the dead-letter list.
+	DeleteDead(id string) error
+}
+
+// ErrNoOutbox is returned when using the dead-letter list of a Messenger
+// without an outbox.
+var ErrNoOutbox = errors.New("messenger: no outbox configured")
+
+// outbox delivers queued messages in the background, keeping the order of the
+// messages sent to each recipient.
+type outbox struct {
+	m     *Messenger
+	store OutboxStore
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// seq makes IDs queued during the same nanosecond unique.
+	seq int
+	// busy holds the lanes which have a send in flight.
+	busy map[string]bool
+	// wait holds the lanes which are backing off after a failure.
+	wait map[string]time.Time
+}
+
+// newOutbox creates an outbox and starts delivering the messages in store.
+func newOutbox(m *Messenger, store OutboxStore) *outbox {
+	o := &outbox{
+		m:     m,
+		store: store,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		busy:  map[string]bool{},
+		wait:  map[string]time.Time{},
+	}
+
+	go o.run()
+
+	return o
+}
+
+// enqueue adds a send to the outbox. replyTo is the ID of the message the send
+// replies to, if any.
+func (o *outbox) enqueue(page int64, to Recipient, replyTo string, contentType string, body []byte) error {
+	now := time.Now()
+
+	o.mu.Lock()
+	o.seq++
+	id := fmt.Sprintf("%020d-%06d", now.UnixNano(), o.seq%1000000)
+	o.mu.Unlock()
+
+	err := o.store.Put(OutboxMessage{
+		ID:          id,
+		Page:        page,
+		Recipient:   to,
+		ReplyTo:     replyTo,
+		ContentType: contentType,
+		Body:        body,
+		Created:     now,
+	})
+	if err != nil {
+		return err
+	}
+
+	o.notify()
+	return nil
+}
+
+// notify wakes the sender loop.
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run is the sender loop.
+func (o *outbox) run() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(outboxPoll)
+	defer ticker.Stop()
+
+	for {
+		o.pump()
+
+		select {
+		case <-o.wake:
+		case <-ticker.C:
+		case <-o.stop:
+			o.wg.Wait()
+			return
+		}
+	}
+}
+
+// pump starts sending the first queued message of each lane which is not
+// already sending or backing off.
+func (o *outbox) pump() {
+	// The store is read under the lock so that a message which is being
+	// removed by deliver is not sent again.
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pending, err := o.store.Pending()
+	if err != nil {
+		o.m.log.Error("could not read outbox", "error", err)
+		return
+	}
+
+	now := time.Now()
+	seen := map[string]bool{}
+
+	for _, msg := range pending {
+		lane := msg.lane()
+		if seen[lane] {
+			continue
+		}
+		seen[lane] = true
+
+		if o.busy[lane] || now.Before(o.wait[lane]) {
+			continue
+		}
+		o.busy[lane] = true
+		delete(o.wait, lane)
+
+		o.wg.Add(1)
+		go o.deliver(msg)
+	}
+}
+
+// deliver sends msg, then removes it from the queue, schedules a retry or
+// moves it to the dead-letter list.
+func (o *outbox) deliver(msg OutboxMessage) {
+	defer o.wg.Done()
+	defer o.notify()
+
+	// Queued messages outlive the span of the handler which sent them, so
+	// they are traced on their own. Each delivery is a single attempt, as
+	// the outbox retries failed sends itself.
+	sendErr := o.m.sendMessage(context.Background(), msg.Page, msg.ContentType, msg.Body, RetryPolicy{})
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	lane := msg.lane()
+	delete(o.busy, lane)
+
+	var err error
+	ce, open := isCircuitOpen(sendErr)
+	te, throttled := isThrottled(sendErr)
+
+	switch {
+	case sendErr == nil:
+		o.m.log.Debug("sent outbox message", msg.fields()...)
+		err = o.store.Delete(msg.ID)
+	case open:
+		// The message was not sent, so it does not count as an attempt.
+		o.m.log.Debug("outbox waiting for circuit breaker", msg.fields()...)
+		o.wait[lane] = ce.Until
+	case throttled:
+		// Nor was it sent while the app is throttled.
+		o.m.log.Debug("outbox waiting for rate limits", msg.fields()...)
+		o.wait[lane] = te.Until
+	case outboxRetry.retryable("POST", sendErr) && msg.Attempts+1 < outboxRetry.Attempts:
+		msg.Attempts++
+		msg.LastError = sendErr.Error()
+		o.wait[lane] = time.Now().Add(outboxRetry.backoff(msg.Attempts))
+		o.m.log.Warn("could not send outbox message", append(msg.fields(), "error", sendErr)...)
+
+		err = o.store.Put(msg)
+	default:
+		msg.Attempts++
+		msg.LastError = sendErr.Error()
+		o.m.log.Error("moved outbox message to dead letters", append(msg.fields(), "error", sendErr)...)
+
+		err = o.store.PutDead(msg)
+		if err == nil {
+			err = o.store.Delete(msg.ID)
+		}
+	}
+
+	if err != nil {
+		o.m.log.Error("could not update outbox", append(msg.fields(), "error", err)...)
+	}
+}
+
+// idle is whether there is nothing left to send.
+func (o *outbox) idle() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.busy) > 0 {
+		return false
+	}
+
+	pending, err := o.store.Pending()
+	return err == nil && len(pending) == 0
+}
+
+// shutdown waits for the queued messages to be sent, or for ctx to be done,
+// then stops the sender loop. Messages which were not sent stay in the store.
+// It may be called more than once.
+func (o *outbox) shutdown(ctx context.Context) error {
+	select {
+	case <-o.done:
+		// Already stopped, queued messages wait for the next Messenger.
+		return nil
+	default:
+	}
+
+	ticker := time.NewTicker(outboxDrainPoll)
+	defer ticker.Stop()
+
+	var err error
+	for err == nil && !o.idle() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	o.stopOnce.Do(func() {
+		close(o.stop)
+	})
+	<-o.done
+
+	return err
+}
+
+// DeadLetters returns the messages which could not be sent by the outbox.
+func (m *Messenger) DeadLetters() ([]OutboxMessage, error) {
+	if m.outbox == nil {
+		return nil, ErrNoOutbox
+	}
+	return m.outbox.store.Dead()
+}
+
+// ReplayDeadLetter moves the message id from the dead-letter list to the end
+// of the outbox, so that it is sent again.
+func (m *Messenger) ReplayDeadLetter(id string) error {
+	if m.outbox == nil {
+		return ErrNoOutbox
+	}
+
+	dead, err := m.outbox.store.Dead()
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range dead {
+		if msg.ID != id {
+			continue
+		}
+
+		err = m.outbox.enqueue(msg.Page, msg.Recipient, msg.ReplyTo, msg.ContentType, msg.Body)
+		if err != nil {
+			return err
+		}
+
+		return m.outbox.store.DeleteDead(id)
+	}
+
+	return fmt.Errorf("messenger: no dead letter %v", id)
+}
+
+// Shutdown waits for the webhooks being forwarded to the Downstreams and for
+// the messages in the outbox to be sent, or for ctx to be done, then stops
+// sending. Messages which were not sent stay in the store and are sent by the
+// next Messenger using it. Shutdown may be called more than once, eg. by both
+// Serve and the program.
+func (m *Messenger) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	if m.forwarder != nil {
+		errs = append(errs, m.forwarder.wait(ctx))
+	}
+
+	if m.outbox != nil {
+		errs = append(errs, m.outbox.shutdown(ctx))
+	}
+
+	return errors.Join(errs...)
+}
+
+// MemoryStore is an OutboxStore which holds messages in memory. Messages are
+// kept across Facebook outages, but not across restarts.
+type MemoryStore struct {
+	mu      sync.Mutex
+	pending map[string]OutboxMessage
+	dead    map[string]OutboxMessage
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pending: map[string]OutboxMessage{},
+		dead:    map[string]OutboxMessage{},
+	}
+}
+
+// Put implements OutboxStore.
+func (s *MemoryStore) Put(msg OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[msg.ID] = msg
+	return nil
+}
+
+// Pending implements OutboxStore.
+func (s *MemoryStore) Pending() ([]OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sortMessages(s.pending), nil
+}
+
+// Delete implements OutboxStore.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pending, id)
+	return nil
+}
+
+// PutDead implements OutboxStore.
+func (s *MemoryStore) PutDead(msg OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dead[msg.ID] = msg
+	return nil
+}
+
+// Dead implements OutboxStore.
+func (s *MemoryStore) Dead() ([]OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sortMessages(s.dead), nil
+}
+
+// DeleteDead implements OutboxStore.
+func (s *MemoryStore) DeleteDead(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.dead, id)
+	return nil
+}
+
+// sortMessages returns the messages in m in order of ID.
+func sortMessages(m map[string]OutboxMessage) []OutboxMessage {
+	msgs := make([]OutboxMessage, 0, len(m))
+	for _, msg := range m {
+		msgs = append(msgs, msg)
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ID < msgs[j].ID
+	})
+	return msgs
+}
@@ -0,0 +1,200 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is an http.RoundTripper answering requests in process.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// sendAPI fakes the Send API, recording the text of each message sent to each
+// recipient. fail, if not nil, returns the status and body to answer a message
+// with, or 0 to accept it.
+type sendAPI struct {
+	fail func(recipient, text string) (int, string)
+
+	mu       sync.Mutex
+	sent     map[string][]string
+	inFlight map[string]bool
+	overlap  bool
+}
+
+func (s *sendAPI) client() *http.Client {
+	return &http.Client{Transport: roundTripFunc(s.roundTrip)}
+}
+
+func (s *sendAPI) roundTrip(req *http.Request) (*http.Response, error) {
+	var msg struct {
+		Recipient struct {
+			ID string `json:"id"`
+		} `json:"recipient"`
+		Message struct {
+			Text string `json:"text"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+		return nil, err
+	}
+	to, text := msg.Recipient.ID, msg.Message.Text
+
+	s.mu.Lock()
+	if s.inFlight[to] {
+		s.overlap = true
+	}
+	s.inFlight[to] = true
+	s.mu.Unlock()
+
+	// Give sends to other recipients a chance to run alongside this one.
+	time.Sleep(5 * time.Millisecond)
+
+	status, body := 0, ""
+	if s.fail != nil {
+		status, body = s.fail(to, text)
+	}
+
+	s.mu.Lock()
+	delete(s.inFlight, to)
+	if status == 0 {
+		s.sent[to] = append(s.sent[to], text)
+	}
+	s.mu.Unlock()
+
+	if status == 0 {
+		status, body = http.StatusOK, `{"recipient_id":"`+to+`","message_id":"mid"}`
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func newOutboxMessenger(t *testing.T, api *sendAPI, store OutboxStore) *Messenger {
+	t.Helper()
+
+	api.sent = map[string][]string{}
+	api.inFlight = map[string]bool{}
+
+	m := New(Options{
+		Token:      "token",
+		HTTPClient: api.client(),
+		Logger:     NopLogger,
+		Outbox:     store,
+	})
+	t.Cleanup(func() {
+		m.Shutdown(context.Background())
+	})
+	return m
+}
+
+func TestOutboxOrder(t *testing.T) {
+	failed := map[string]bool{}
+	api := &sendAPI{
+		// The first message to 1 fails once, and must still be sent before
+		// the messages queued after it.
+		fail: func(to, text string) (int, string) {
+			if text == "1-0" && !failed[text] {
+				failed[text] = true
+				return http.StatusInternalServerError, `{"error":{"message":"oops","code":2}}`
+			}
+			return 0, ""
+		},
+	}
+	store := NewMemoryStore()
+	m := newOutboxMessenger(t, api, store)
+
+	want := map[string][]string{}
+	for i := 0; i < 5; i++ {
+		for _, to := range []int64{1, 2, 3} {
+			text := fmt.Sprintf("%v-%v", to, i)
+			if err := m.ResponseTo(0, Recipient{ID: to}).Text(text); err != nil {
+				t.Fatalf("Text(%q) = %v", text, err)
+			}
+
+			key := fmt.Sprint(to)
+			want[key] = append(want[key], text)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if !reflect.DeepEqual(api.sent, want) {
+		t.Errorf("sent %v, want %v", api.sent, want)
+	}
+	if api.overlap {
+		t.Errorf("messages to the same recipient were sent concurrently")
+	}
+
+	if pending, _ := store.Pending(); len(pending) != 0 {
+		t.Errorf("%v messages left in the outbox", len(pending))
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+	api := &sendAPI{
+		// Invalid requests are not retried.
+		fail: func(to, text string) (int, string) {
+			if text == "bad" {
+				return http.StatusBadRequest, `{"error":{"message":"invalid parameter","code":100}}`
+			}
+			return 0, ""
+		},
+	}
+	store := NewMemoryStore()
+	m := newOutboxMessenger(t, api, store)
+
+	r := m.ResponseTo(0, Recipient{ID: 1})
+	for _, text := range []string{"first", "bad", "last"} {
+		if err := r.Text(text); err != nil {
+			t.Fatalf("Text(%q) = %v", text, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	api.mu.Lock()
+	sent := api.sent["1"]
+	api.mu.Unlock()
+
+	if want := []string{"first", "last"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+
+	dead, err := m.DeadLetters()
+	if err != nil {
+		t.Fatalf("DeadLetters() = %v", err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("%v dead letters, want 1", len(dead))
+	}
+	if d := dead[0]; d.Recipient.ID != 1 || d.Attempts != 1 || !strings.Contains(d.LastError, "invalid parameter") {
+		t.Errorf("dead letter = %+v", d)
+	}
+}
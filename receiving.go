@@ -1,5 +1,7 @@
 package messenger
 
+import "encoding/json"
+
 // Receive is the format in which webhook events are sent.
 type Receive struct {
 	// Object should always be `page`. (I don't quite understand why)
@@ -17,9 +19,14 @@ type Entry struct {
 	Time int64 `json:"time"`
 	// Messaging is the events that were sent in this Entry
 	Messaging []MessageInfo `json:"messaging"`
+	// Standby is the events that were sent in this Entry while another app
+	// controlled the conversation. See the handover protocol.
+	Standby []MessageInfo `json:"standby"`
 }
 
-// MessageInfo is an event that is fired by the webhook.
+// MessageInfo is an event that is fired by the webhook. Only the fields common
+// to all events are decoded into it, Raw holds the whole event, which is decoded
+// by the registered EventDecoder of its type.
 type MessageInfo struct {
 	// Sender is who the event was sent from.
 	Sender Sender `json:"sender"`
@@ -27,16 +34,19 @@ type MessageInfo struct {
 	Recipient Recipient `json:"recipient"`
 	// Timestamp is the true time the event was triggered.
 	Timestamp int64 `json:"timestamp"`
-	// Message is the contents of a message if it is a MessageAction.
-	// Nil if it is not a MessageAction.
-	Message *Message `json:"message"`
-	// Delivery is the contents of a message if it is a DeliveryAction.
-	// Nil if it is not a DeliveryAction.
-	Delivery *Delivery `json:"delivery"`
+	// Raw is the event as it was sent by Facebook.
+	Raw json.RawMessage `json:"-"`
+}
 
-	PostBack *PostBack `json:"postback"`
+// UnmarshalJSON decodes a MessageInfo, keeping a copy of the raw event.
+func (mi *MessageInfo) UnmarshalJSON(b []byte) error {
+	type info MessageInfo
+	if err := json.Unmarshal(b, (*info)(mi)); err != nil {
+		return err
+	}
 
-	Read *Read `json:"read"`
+	mi.Raw = append(json.RawMessage(nil), b...)
+	return nil
 }
 
 // Sender is who the message was sent from.
@@ -46,7 +56,10 @@ type Sender struct {
 
 // Recipient is who the message was sent to.
 type Recipient struct {
-	ID int64 `json:"id,string"`
+	ID int64 `json:"id,string,omitempty"`
+	// UserRef is used instead of ID to send the first message to a person who
+	// opted in through the checkbox plugin.
+	UserRef string `json:"user_ref,omitempty"`
 }
 
 // Attachment is a file which used in a message.
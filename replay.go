@@ -0,0 +1,155 @@
+package messenger
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ReplayOptions are the settings of Replay.
+type ReplayOptions struct {
+	// Path is the path the webhooks are sent to. Empty means "/".
+	Path string
+	// Speed is how much faster than real time webhooks are replayed, eg. 1
+	// keeps the original pace and 10 replays them ten times as fast. 0
+	// replays them as fast as possible.
+	Speed float64
+	// Sender, if not 0, only replays webhooks with an event from that person.
+	Sender int64
+	// From and To, if not zero, only replay webhooks received in that
+	// window.
+	From time.Time
+	To   time.Time
+	// AppSecret, if set, signs the webhooks again with that secret, eg. for a
+	// staging app. Otherwise the recorded signatures are sent.
+	AppSecret string
+	// OnResult, if not nil, is called with the status the handler replied to
+	// each webhook with.
+	OnResult func(e JournalEntry, status int)
+}
+
+// Replay sends the webhooks recorded in the journal r to h, eg. the Handler of
+// a Messenger, and returns how many were sent. It stops when ctx is done.
+func Replay(ctx context.Context, r io.Reader, h http.Handler, opts ReplayOptions) (int, error) {
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+
+	var (
+		n    int
+		last time.Time
+	)
+
+	err := ReadJournal(r, func(e JournalEntry) error {
+		if !opts.match(e) {
+			return nil
+		}
+
+		if opts.Speed > 0 && !last.IsZero() && e.Time.After(last) {
+			wait := time.Duration(float64(e.Time.Sub(last)) / opts.Speed)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		last = e.Time
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", opts.Path, strings.NewReader(e.Body))
+		if err != nil {
+			return err
+		}
+		req.Header = e.Header.Clone()
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		req.Header.Del("Content-Length")
+
+		if opts.AppSecret != "" {
+			body := []byte(e.Body)
+			req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(sign(sha1.New, opts.AppSecret, body)))
+			req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign(sha256.New, opts.AppSecret, body)))
+		}
+
+		w := &statusRecorder{header: http.Header{}}
+		h.ServeHTTP(w, req)
+		n++
+
+		if opts.OnResult != nil {
+			opts.OnResult(e, w.status())
+		}
+		return nil
+	})
+
+	return n, err
+}
+
+// match is whether e passes the filters of opts.
+func (opts ReplayOptions) match(e JournalEntry) bool {
+	if !opts.From.IsZero() && e.Time.Before(opts.From) {
+		return false
+	}
+	if !opts.To.IsZero() && e.Time.After(opts.To) {
+		return false
+	}
+	if opts.Sender == 0 {
+		return true
+	}
+
+	var rec Receive
+	if err := json.Unmarshal([]byte(e.Body), &rec); err != nil {
+		return false
+	}
+
+	for _, entry := range rec.Entry {
+		for _, infos := range [][]MessageInfo{entry.Messaging, entry.Standby} {
+			for _, info := range infos {
+				if info.Sender.ID == opts.Sender {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// statusRecorder is an http.ResponseWriter which only keeps the status.
+type statusRecorder struct {
+	header http.Header
+	code   int
+}
+
+func (w *statusRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+// status returns the status written, which is 200 if none was.
+func (w *statusRecorder) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
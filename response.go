@@ -2,13 +2,13 @@ package messenger
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"image"
 	"image/jpeg"
 	"io"
 	"mime/multipart"
-	"net/http"
 )
 
 const (
@@ -16,10 +16,62 @@ const (
 	SendMessageURL = "https://graph.facebook.com/v2.6/me/messages"
 )
 
+// ErrPassive is returned when sending a message with a passive Response.
+var ErrPassive = errors.New("messenger: cannot send with a passive response")
+
 // Response is used for responding to events with messages.
+//
+// When the Messenger has an outbox, see Options.Outbox, messages are queued
+// and sent in the background. The error returned by a send is then only that
+// of queueing the message.
 type Response struct {
-	token string
-	to    Recipient
+	m        *Messenger
+	ctx      context.Context
+	page     int64
+	to       Recipient
+	mid      string
+	metadata string
+	passive  bool
+}
+
+// Context returns the context of the Response, which carries the span of the
+// handler it was passed to, see Options.Tracer. Messages sent with the
+// Response are traced as children of that span. It is not cancelled when the
+// webhook request is done, so that handlers may reply later.
+func (r *Response) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a copy of the Response whose messages are traced as
+// children of the span in ctx, eg. for a Response created with ResponseTo.
+func (r *Response) WithContext(ctx context.Context) *Response {
+	c := *r
+	c.ctx = ctx
+	return &c
+}
+
+// PageID is the ID of the page the Response sends messages from. 0 means the
+// default page.
+func (r *Response) PageID() int64 {
+	return r.page
+}
+
+// Passive is whether the Response was created for a standby event. Passive
+// Responses cannot send messages and return ErrPassive instead.
+func (r *Response) Passive() bool {
+	return r.passive
+}
+
+// WithMetadata returns a copy of the Response which sends metadata along with
+// each message. The metadata comes back on the echo of the message, see
+// Message.Metadata.
+func (r *Response) WithMetadata(metadata string) *Response {
+	c := *r
+	c.metadata = metadata
+	return &c
 }
 
 // Text sends a textual message.
@@ -34,32 +86,19 @@ func (r *Response) TextWithReplies(message string, replies []QuickReply) error {
 		Message: MessageData{
 			Text:         message,
 			QuickReplies: replies,
+			Metadata:     r.metadata,
 		},
 	}
 
-	data, err := json.Marshal(m)
-	if err != nil {
-		return nil
-	}
-
-	req, err := http.NewRequest("POST", SendMessageURL, bytes.NewBuffer(data))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.URL.RawQuery = "access_token=" + r.token
-
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
-	return err
+	return r.send(m)
 }
 
 // Image sends an image.
 func (r *Response) Image(im image.Image) error {
+	if r.passive {
+		return ErrPassive
+	}
+
 	var b bytes.Buffer
 	w := multipart.NewWriter(&b)
 
@@ -79,28 +118,24 @@ func (r *Response) Image(im image.Image) error {
 		return err
 	}
 
-	w.WriteField("recipient", fmt.Sprintf(`{"id":"%v"}`, r.to.ID))
-	w.WriteField("message", `{"attachment":{"type":"image", "payload":{}}}`)
-
-	req, err := http.NewRequest("POST", SendMessageURL, &b)
+	recipient, err := json.Marshal(r.to)
 	if err != nil {
 		return err
 	}
 
-	req.URL.RawQuery = "access_token=" + r.token
-
-	req.Header.Set("Content-Type", w.FormDataContentType())
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	message, err := json.Marshal(ImageMessageData{
+		Attachment: Attachment{Type: "image"},
+		Metadata:   r.metadata,
+	})
 	if err != nil {
 		return err
 	}
 
-	var res bytes.Buffer
-	res.ReadFrom(resp.Body)
-	fmt.Println(res.String(), "DONE!")
-	return nil
+	w.WriteField("recipient", string(recipient))
+	w.WriteField("message", string(message))
+	w.Close()
+
+	return r.deliver(w.FormDataContentType(), b.Bytes())
 }
 
 // ButtonTemplate sends a message with the main contents being button elements
@@ -117,28 +152,11 @@ func (r *Response) ButtonTemplate(text string, buttons *[]StructuredMessageButto
 					Elements:     nil,
 				},
 			},
+			Metadata: r.metadata,
 		},
 	}
 
-	data, err := json.Marshal(m)
-	if err != nil {
-		return nil
-	}
-
-	req, err := http.NewRequest("POST", SendMessageURL, bytes.NewBuffer(data))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.URL.RawQuery = "access_token=" + r.token
-
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
-	return err
+	return r.send(m)
 }
 
 // GenericTemplate is a message which allows for structural elements to be sent
@@ -154,28 +172,51 @@ func (r *Response) GenericTemplate(text string, elements *[]StructuredMessageEle
 					Elements:     elements,
 				},
 			},
+			Metadata: r.metadata,
 		},
 	}
 
-	data, err := json.Marshal(m)
-	if err != nil {
-		return nil
+	return r.send(m)
+}
+
+// send sends v, a message encoded as JSON, to the Send API.
+func (r *Response) send(v interface{}) error {
+	if r.passive {
+		return ErrPassive
 	}
 
-	req, err := http.NewRequest("POST", SendMessageURL, bytes.NewBuffer(data))
+	data, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.URL.RawQuery = "access_token=" + r.token
+	return r.deliver("application/json", data)
+}
+
+// deliver sends body to the Send API, or queues it in the outbox if the
+// Messenger has one.
+func (r *Response) deliver(contentType string, body []byte) error {
+	if r.m.outbox != nil {
+		return r.m.outbox.enqueue(r.page, r.to, r.mid, contentType, body)
+	}
 
-	client := &http.Client{}
+	err := r.m.sendMessage(r.Context(), r.page, contentType, body, r.m.retry)
+	if err != nil {
+		r.m.log.Warn("could not send message", append(r.fields(), "error", err)...)
+		return err
+	}
 
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
+	r.m.log.Debug("sent message", r.fields()...)
+	return nil
+}
 
-	return err
+// fields returns the key-value pairs identifying the sends of r in a log line.
+func (r *Response) fields() []interface{} {
+	return []interface{}{
+		"page", r.page,
+		"recipient", r.to.ID,
+		"mid", r.mid,
+	}
 }
 
 // SendMessage is the information sent in an API request to Facebook.
@@ -188,6 +229,13 @@ type SendMessage struct {
 type MessageData struct {
 	Text         string       `json:"text,omitempty"`
 	QuickReplies []QuickReply `json:"quick_replies,omitempty"`
+	Metadata     string       `json:"metadata,omitempty"`
+}
+
+// ImageMessageData is the message sent along with an uploaded image.
+type ImageMessageData struct {
+	Attachment Attachment `json:"attachment"`
+	Metadata   string     `json:"metadata,omitempty"`
 }
 
 // SendStructuredMessage is a structured message template.
@@ -199,6 +247,7 @@ type SendStructuredMessage struct {
 // StructuredMessageData is an attachment sent with a structured message.
 type StructuredMessageData struct {
 	Attachment StructuredMessageAttachment `json:"attachment"`
+	Metadata   string                      `json:"metadata,omitempty"`
 }
 
 // StructuredMessageAttachment is the attachment of a structured message.
@@ -229,7 +278,19 @@ type StructuredMessageElement struct {
 // StructuredMessageButton is a response containing buttons
 type StructuredMessageButton struct {
 	Type    string `json:"type"`
-	URL     string `json:"url"`
-	Title   string `json:"title"`
-	Payload string `json:"payload"`
+	URL     string `json:"url,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Payload string `json:"payload,omitempty"`
+}
+
+// LogInButton creates a button which opens url, the log in page of the
+// website, to link the account of the person. The page is passed the
+// account_linking_token and redirect_uri parameters.
+func LogInButton(url string) StructuredMessageButton {
+	return StructuredMessageButton{Type: "account_link", URL: url}
+}
+
+// LogOutButton creates a button which unlinks the account of the person.
+func LogOutButton() StructuredMessageButton {
+	return StructuredMessageButton{Type: "account_unlink"}
 }
@@ -0,0 +1,146 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseOutlivesWebhookRequest(t *testing.T) {
+	api := &sendAPI{sent: map[string][]string{}, inFlight: map[string]bool{}}
+	m := New(Options{Token: "token", HTTPClient: api.client(), Logger: NopLogger})
+
+	returned := make(chan struct{})
+	errc := make(chan error, 1)
+	m.HandleMessage(func(msg Message, r *Response) {
+		go func() {
+			<-returned
+			errc <- r.Text("later")
+		}()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	body := `{"object":"page","entry":[{"id":"1","time":1,"messaging":[{"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":1,"message":{"mid":"m1","text":"hi"}}]}]}`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+
+	// The server cancels the context of a request once it is answered.
+	cancel()
+	close(returned)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Text() = %v", err)
+	}
+	if want := []string{"later"}; !reflect.DeepEqual(api.sent["2"], want) {
+		t.Errorf("sent %v, want %v", api.sent["2"], want)
+	}
+}
+
+func TestResponseUsesPageToken(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		tokens = map[string]string{}
+	)
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var msg SendMessage
+		if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		tokens[msg.Message.Text] = req.URL.Query().Get("access_token")
+		mu.Unlock()
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"recipient_id":"2","message_id":"mid"}`)),
+			Request:    req,
+		}, nil
+	})}
+
+	m := New(Options{
+		Token:      "default",
+		PageTokens: PageTokens{1: "page1", 2: "page2"},
+		HTTPClient: client,
+		Logger:     NopLogger,
+	})
+	m.HandleMessage(func(msg Message, r *Response) {
+		if err := r.Text(msg.Text); err != nil {
+			t.Errorf("Text() = %v", err)
+		}
+	})
+
+	body := `{"object":"page","entry":[
+		{"id":"1","time":1,"messaging":[{"sender":{"id":"3"},"recipient":{"id":"1"},"timestamp":1,"message":{"mid":"m1","text":"to page 1"}}]},
+		{"id":"2","time":1,"messaging":[{"sender":{"id":"3"},"recipient":{"id":"2"},"timestamp":1,"message":{"mid":"m2","text":"to page 2"}}]}
+	]}`
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	want := map[string]string{"to page 1": "page1", "to page 2": "page2"}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("sent with tokens %v, want %v", tokens, want)
+	}
+}
+
+func TestResponseLogsEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		outbox OutboxStore
+		want   string
+	}{
+		{name: "direct", want: `level=WARN msg="could not send message" page=1 recipient=2 mid=m1`},
+		{name: "outbox", outbox: NewMemoryStore(), want: `level=ERROR msg="moved outbox message to dead letters" page=1 recipient=2 mid=m1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &sendAPI{
+				sent:     map[string][]string{},
+				inFlight: map[string]bool{},
+				fail: func(to, text string) (int, string) {
+					return http.StatusBadRequest, `{"error":{"message":"invalid parameter","code":100}}`
+				},
+			}
+
+			var buf bytes.Buffer
+			m := New(Options{
+				Token:      "token",
+				HTTPClient: api.client(),
+				Logger:     slog.New(slog.NewTextHandler(&buf, nil)),
+				Outbox:     tt.outbox,
+			})
+			m.HandleMessage(func(msg Message, r *Response) {
+				r.Text("hello")
+			})
+
+			postEvents(t, m, "messaging", `"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":1,"message":{"mid":"m1","text":"hi"}`)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := m.Shutdown(ctx); err != nil {
+				t.Fatalf("Shutdown() = %v", err)
+			}
+
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("log lines do not contain %q:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultThrottleAt is the usage percentage above which requests are
+	// slowed down when Options.ThrottleAt is 0.
+	DefaultThrottleAt = 90
+
+	// throttleStep is the delay added for each percent of usage above the
+	// throttling threshold.
+	throttleStep = 200 * time.Millisecond
+	// maxThrottleDelay caps how long a request waits for the usage to go
+	// down. Sends usually run inside a webhook request, which Facebook
+	// delivers again if it is not answered within 20 seconds, so requests
+	// which would wait longer fail with a *ThrottledError instead.
+	maxThrottleDelay = 3 * time.Second
+	// usageTTL is how long the usage reported by Facebook is trusted for.
+	usageTTL = time.Minute
+)
+
+// DefaultRetryPolicy is a RetryPolicy suitable for most bots.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  3,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  10 * time.Second,
+}
+
+// RetryPolicy decides how failed Graph API requests are retried.
+//
+// Requests rejected because of rate limiting, or which could not connect to
+// Facebook, are always safe to retry. Server errors and timeouts are only
+// retried for GET requests, as a message may have been sent even though the
+// request failed, unless RetryUnsafe is set.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first.
+	// Values below 2 disable retries.
+	Attempts int
+	// BaseDelay is the delay before the first retry. It doubles for each
+	// retry after it, and a random jitter is applied.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts.
+	MaxDelay time.Duration
+	// RetryUnsafe allows retrying sends which may have reached Facebook.
+	// These may deliver a message twice.
+	RetryUnsafe bool
+}
+
+// RateLimited is whether the error was caused by hitting a rate limit of the
+// Graph API. Requests which were rate limited were not processed.
+func (e *Error) RateLimited() bool {
+	switch e.Code {
+	case 4, 17, 32, 613:
+		return true
+	}
+	return false
+}
+
+// retryable is whether a request with method which failed with err may be
+// retried.
+func (p RetryPolicy) retryable(method string, err error) bool {
+	safe := method == "GET" || method == "HEAD" || p.RetryUnsafe
+
+	// Retrying straight away would only hit the open breaker, or the rate
+	// limits, again.
+	if _, ok := isCircuitOpen(err); ok {
+		return false
+	}
+	if _, ok := isThrottled(err); ok {
+		return false
+	}
+
+	var ge *Error
+	if errors.As(err, &ge) {
+		if ge.RateLimited() {
+			return true
+		}
+		return safe && (ge.StatusCode >= 500 || ge.Transient)
+	}
+
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return true
+	}
+
+	return safe
+}
+
+// backoff returns the delay before the retry following attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << uint(attempt-1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	// Full jitter spreads retries from many goroutines over the whole window.
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// ThrottledError is returned instead of sending a request while the app is
+// blocked, or close to being blocked, by the Graph API rate limits.
+type ThrottledError struct {
+	// Until is when the usage is expected to allow requests again.
+	Until time.Time
+}
+
+// Error implements the error interface.
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("messenger: throttled until %v", e.Until.Format(time.RFC3339))
+}
+
+// isThrottled is whether err was caused by the app being throttled.
+func isThrottled(err error) (*ThrottledError, bool) {
+	var te *ThrottledError
+	ok := errors.As(err, &te)
+	return te, ok
+}
+
+// usage tracks how close the app is to the Graph API rate limits, as reported
+// in the headers of each response.
+type usage struct {
+	throttleAt int
+
+	mu      sync.Mutex
+	percent int
+	blocked time.Time
+	updated time.Time
+}
+
+// appUsage is the format of the X-App-Usage and X-Page-Usage headers.
+type appUsage struct {
+	CallCount    int `json:"call_count"`
+	TotalTime    int `json:"total_time"`
+	TotalCPUTime int `json:"total_cputime"`
+}
+
+// businessUsage is the format of each entry of the
+// X-Business-Use-Case-Usage header.
+type businessUsage struct {
+	appUsage
+	Type                  string `json:"type"`
+	EstimatedTimeToRegain int    `json:"estimated_time_to_regain_access"`
+}
+
+// max is the highest of the percentages in u.
+func (u appUsage) max() int {
+	m := u.CallCount
+	if u.TotalTime > m {
+		m = u.TotalTime
+	}
+	if u.TotalCPUTime > m {
+		m = u.TotalCPUTime
+	}
+	return m
+}
+
+// update records the usage reported in h.
+func (u *usage) update(h http.Header) {
+	percent := 0
+	var regain time.Duration
+
+	for _, name := range []string{"X-App-Usage", "X-Page-Usage"} {
+		var au appUsage
+		if err := json.Unmarshal([]byte(h.Get(name)), &au); err == nil && au.max() > percent {
+			percent = au.max()
+		}
+	}
+
+	var bu map[string][]businessUsage
+	if err := json.Unmarshal([]byte(h.Get("X-Business-Use-Case-Usage")), &bu); err == nil {
+		for _, entries := range bu {
+			for _, b := range entries {
+				if b.max() > percent {
+					percent = b.max()
+				}
+
+				r := time.Duration(b.EstimatedTimeToRegain) * time.Minute
+				if r > regain {
+					regain = r
+				}
+			}
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	u.percent = percent
+	u.updated = now
+	if regain > 0 {
+		u.blocked = now.Add(regain)
+	}
+}
+
+// delay returns how long to wait before the next request so as not to be
+// blocked by Facebook.
+func (u *usage) delay() time.Duration {
+	if u.throttleAt < 0 {
+		return 0
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var d time.Duration
+
+	now := time.Now()
+	switch {
+	case now.Before(u.blocked):
+		d = u.blocked.Sub(now)
+	case now.Sub(u.updated) <= usageTTL && u.percent >= u.throttleAt:
+		d = time.Duration(u.percent-u.throttleAt+1) * throttleStep
+	}
+
+	return d
+}
+
+// wait waits before a request so as not to be blocked by Facebook. It fails
+// with a *ThrottledError straight away if that would take longer than
+// maxThrottleDelay.
+func (u *usage) wait(ctx context.Context) error {
+	d := u.delay()
+	if d > maxThrottleDelay {
+		return &ThrottledError{Until: time.Now().Add(d)}
+	}
+	return sleep(ctx, d)
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
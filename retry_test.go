@@ -0,0 +1,172 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	serverError := &Error{Message: "oops", Code: 2, StatusCode: 500}
+	dialError := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readError := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}
+
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		method string
+		err    error
+		want   bool
+	}{
+		{name: "GET server error", method: "GET", err: serverError, want: true},
+		{name: "POST server error", method: "POST", err: serverError, want: false},
+		{name: "POST server error unsafe", policy: RetryPolicy{RetryUnsafe: true}, method: "POST", err: serverError, want: true},
+		{name: "POST transient", method: "POST", err: &Error{Code: 1, Transient: true, StatusCode: 400}, want: false},
+		{name: "GET transient", method: "GET", err: &Error{Code: 1, Transient: true, StatusCode: 400}, want: true},
+		{name: "POST rate limit 4", method: "POST", err: &Error{Code: 4, StatusCode: 400}, want: true},
+		{name: "POST rate limit 17", method: "POST", err: &Error{Code: 17, StatusCode: 400}, want: true},
+		{name: "POST rate limit 32", method: "POST", err: &Error{Code: 32, StatusCode: 400}, want: true},
+		{name: "POST rate limit 613", method: "POST", err: &Error{Code: 613, StatusCode: 400}, want: true},
+		{name: "GET invalid parameter", method: "GET", err: &Error{Code: 100, StatusCode: 400}, want: false},
+		{name: "POST dial error", method: "POST", err: dialError, want: true},
+		{name: "POST read error", method: "POST", err: readError, want: false},
+		{name: "GET read error", method: "GET", err: readError, want: true},
+		{name: "circuit open", method: "GET", err: &CircuitOpenError{Until: time.Now()}, want: false},
+		{name: "throttled", method: "GET", err: &ThrottledError{Until: time.Now()}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.retryable(tt.method, tt.err); got != tt.want {
+				t.Errorf("retryable(%v, %v) = %v, want %v", tt.method, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		max    time.Duration
+	}{
+		{name: "capped", policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}, max: time.Minute},
+		{name: "uncapped", policy: RetryPolicy{BaseDelay: time.Second}, max: 1<<63 - 1},
+		{name: "no delay", policy: RetryPolicy{}, max: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Large attempts overflow the doubling of BaseDelay.
+			for attempt := 1; attempt <= 100; attempt++ {
+				if d := tt.policy.backoff(attempt); d < 0 || d > tt.max {
+					t.Fatalf("backoff(%v) = %v, want between 0 and %v", attempt, d, tt.max)
+				}
+			}
+		})
+	}
+
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(2); d > 2*time.Second {
+			t.Fatalf("backoff(2) = %v, want at most %v", d, 2*time.Second)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		name       string
+		throttleAt int
+		headers    map[string]string
+		min, max   time.Duration
+		throttled  bool
+	}{
+		{
+			name:       "below threshold",
+			throttleAt: 90,
+			headers:    map[string]string{"X-App-Usage": `{"call_count":50,"total_cputime":10,"total_time":20}`},
+		},
+		{
+			name:       "app usage above threshold",
+			throttleAt: 90,
+			headers:    map[string]string{"X-App-Usage": `{"call_count":20,"total_cputime":95,"total_time":20}`},
+			min:        6 * throttleStep,
+			max:        6 * throttleStep,
+		},
+		{
+			name:       "page usage above threshold",
+			throttleAt: 90,
+			headers: map[string]string{
+				"X-App-Usage":  `{"call_count":10}`,
+				"X-Page-Usage": `{"call_count":91}`,
+			},
+			min: 2 * throttleStep,
+			max: 2 * throttleStep,
+		},
+		{
+			name:       "business use case usage",
+			throttleAt: 90,
+			headers:    map[string]string{"X-Business-Use-Case-Usage": `{"123":[{"type":"messenger","call_count":92,"total_cputime":10,"total_time":10,"estimated_time_to_regain_access":0}]}`},
+			min:        3 * throttleStep,
+			max:        3 * throttleStep,
+		},
+		{
+			name:       "blocked",
+			throttleAt: 90,
+			headers:    map[string]string{"X-Business-Use-Case-Usage": `{"123":[{"type":"messenger","call_count":100,"estimated_time_to_regain_access":5}]}`},
+			min:        4 * time.Minute,
+			max:        5 * time.Minute,
+			throttled:  true,
+		},
+		{
+			name:       "disabled",
+			throttleAt: -1,
+			headers:    map[string]string{"X-App-Usage": `{"call_count":100}`},
+		},
+		{
+			name:       "malformed",
+			throttleAt: 90,
+			headers:    map[string]string{"X-App-Usage": `{"call_count":`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &usage{throttleAt: tt.throttleAt}
+
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			u.update(h)
+
+			if d := u.delay(); d < tt.min || d > tt.max {
+				t.Errorf("delay() = %v, want between %v and %v", d, tt.min, tt.max)
+			}
+
+			// A done context keeps wait from sleeping, but not from failing
+			// straight away when throttled.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, throttled := isThrottled(u.wait(ctx))
+			if throttled != tt.throttled {
+				t.Errorf("wait() throttled = %v, want %v", throttled, tt.throttled)
+			}
+		})
+	}
+}
+
+func TestUsageExpires(t *testing.T) {
+	u := &usage{throttleAt: 90}
+	u.update(http.Header{"X-App-Usage": {`{"call_count":100}`}})
+	u.updated = time.Now().Add(-2 * usageTTL)
+
+	if d := u.delay(); d != 0 {
+		t.Errorf("delay() of stale usage = %v, want 0", d)
+	}
+}
@@ -0,0 +1,193 @@
+package messenger
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// PayloadActionKey is the key which holds the name of a JSON or key=value
+// payload, eg. {"action":"ORDER_CANCEL","code":"X1"}.
+const PayloadActionKey = "action"
+
+// Params are the named values extracted from a payload by a router.
+type Params map[string]string
+
+// PayloadHandler is a handler used for responding to a postback or quick reply
+// whose payload was matched by a route.
+type PayloadHandler func(PostBack, Params, *Response)
+
+// payloadRoute is a single pattern registered with OnPostback.
+type payloadRoute struct {
+	// segments is the pattern split on ":". The first segment is the name of
+	// the payload, following segments are literals or {params}.
+	segments []string
+	handler  PayloadHandler
+}
+
+// payloadRouter matches postback and quick reply payloads against routes.
+type payloadRouter struct {
+	routes   []payloadRoute
+	fallback PayloadHandler
+}
+
+// payload is a postback or quick reply payload broken into its parts.
+type payload struct {
+	name string
+	// args are the colon separated values following the name. Nil for
+	// structured payloads.
+	args []string
+	// params are the values of a JSON or key=value payload.
+	params     Params
+	structured bool
+}
+
+// OnPostback adds a route to the Messenger which will be triggered when a
+// postback or quick reply payload matches pattern.
+//
+// A pattern is a name optionally followed by colon separated segments, each of
+// which is either a literal or a {param}. "ORDER_CANCEL:{code}" matches the
+// payload "ORDER_CANCEL:X1" with the param code set to "X1". The same pattern
+// matches the structured payloads {"action":"ORDER_CANCEL","code":"X1"},
+// "ORDER_CANCEL?code=X1" and "action=ORDER_CANCEL&code=X1"; literal segments
+// after the name only match colon separated payloads.
+//
+// Routes are tried in the order they were added. Payloads which are handled by
+// a route are not passed on to the PostBackHandlers or MessageHandlers.
+func (m *Messenger) OnPostback(pattern string, f PayloadHandler) {
+	m.payloads.routes = append(m.payloads.routes, payloadRoute{
+		segments: strings.Split(pattern, ":"),
+		handler:  f,
+	})
+}
+
+// OnPostbackDefault sets the PayloadHandler which will be triggered when a
+// postback payload does not match any route. Quick replies which match no
+// route are passed on to the text rules and MessageHandlers instead.
+func (m *Messenger) OnPostbackDefault(f PayloadHandler) {
+	m.payloads.fallback = f
+}
+
+// route triggers the handler of the first route matching p, or the default if
+// fallback is set. It returns false if no route, including the default,
+// handled the payload.
+func (pr *payloadRouter) route(p PostBack, r *Response, fallback bool) bool {
+	if len(pr.routes) == 0 && (!fallback || pr.fallback == nil) {
+		return false
+	}
+
+	parsed := parsePayload(p.Payload)
+	for _, rt := range pr.routes {
+		if params, ok := rt.match(parsed); ok {
+			rt.handler(p, params, r)
+			return true
+		}
+	}
+
+	if !fallback || pr.fallback == nil {
+		return false
+	}
+
+	pr.fallback(p, parsed.params, r)
+	return true
+}
+
+// match reports whether p matches the route, returning the extracted params.
+func (rt payloadRoute) match(p payload) (Params, bool) {
+	if rt.segments[0] != p.name {
+		return nil, false
+	}
+
+	rest := rt.segments[1:]
+	if p.structured {
+		for _, s := range rest {
+			name, ok := param(s)
+			if !ok {
+				return nil, false
+			}
+
+			if _, ok := p.params[name]; !ok {
+				return nil, false
+			}
+		}
+		return p.params, true
+	}
+
+	if len(rest) != len(p.args) {
+		return nil, false
+	}
+
+	params := Params{}
+	for i, s := range rest {
+		if name, ok := param(s); ok {
+			params[name] = p.args[i]
+			continue
+		}
+
+		if s != p.args[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// param returns the name of a {param} pattern segment.
+func param(segment string) (string, bool) {
+	if len(segment) < 3 || segment[0] != '{' || segment[len(segment)-1] != '}' {
+		return "", false
+	}
+	return segment[1 : len(segment)-1], true
+}
+
+// parsePayload breaks a raw payload into its name and values.
+func parsePayload(raw string) payload {
+	raw = strings.TrimSpace(raw)
+
+	if strings.HasPrefix(raw, "{") {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &fields); err == nil {
+			params := Params{}
+			for k, v := range fields {
+				var s string
+				if err := json.Unmarshal(v, &s); err != nil {
+					s = string(v)
+				}
+				params[k] = s
+			}
+
+			name := params[PayloadActionKey]
+			delete(params, PayloadActionKey)
+
+			return payload{name: name, params: params, structured: true}
+		}
+	}
+
+	if i := strings.Index(raw, "?"); i >= 0 {
+		if values, err := url.ParseQuery(raw[i+1:]); err == nil {
+			return payload{name: raw[:i], params: flatten(values), structured: true}
+		}
+	}
+
+	if strings.Contains(raw, "=") && !strings.Contains(raw, ":") {
+		if values, err := url.ParseQuery(raw); err == nil && values.Get(PayloadActionKey) != "" {
+			params := flatten(values)
+			name := params[PayloadActionKey]
+			delete(params, PayloadActionKey)
+
+			return payload{name: name, params: params, structured: true}
+		}
+	}
+
+	segments := strings.Split(raw, ":")
+	return payload{name: segments[0], args: segments[1:], params: Params{}}
+}
+
+// flatten keeps the first value of each key.
+func flatten(values url.Values) Params {
+	params := Params{}
+	for k := range values {
+		params[k] = values.Get(k)
+	}
+	return params
+}
@@ -0,0 +1,133 @@
+package messenger
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePayload(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want payload
+	}{
+		{
+			raw:  "MENU",
+			want: payload{name: "MENU", args: []string{}, params: Params{}},
+		},
+		{
+			raw:  " ORDER_CANCEL:X1 ",
+			want: payload{name: "ORDER_CANCEL", args: []string{"X1"}, params: Params{}},
+		},
+		{
+			raw:  `{"action":"ORDER_CANCEL","code":"X1","qty":2}`,
+			want: payload{name: "ORDER_CANCEL", params: Params{"code": "X1", "qty": "2"}, structured: true},
+		},
+		{
+			raw:  "ORDER_CANCEL?code=X1",
+			want: payload{name: "ORDER_CANCEL", params: Params{"code": "X1"}, structured: true},
+		},
+		{
+			raw:  "action=ORDER_CANCEL&code=X1",
+			want: payload{name: "ORDER_CANCEL", params: Params{"code": "X1"}, structured: true},
+		},
+		{
+			raw:  "a=b:c",
+			want: payload{name: "a=b", args: []string{"c"}, params: Params{}},
+		},
+		{
+			raw:  "{not json",
+			want: payload{name: "{not json", args: []string{}, params: Params{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := parsePayload(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePayload(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPayloadRouteMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		raw     string
+		want    Params
+		ok      bool
+	}{
+		{pattern: "MENU", raw: "MENU", want: Params{}, ok: true},
+		{pattern: "MENU", raw: "HELP", ok: false},
+		{pattern: "MENU", raw: "MENU:1", ok: false},
+		{pattern: "ORDER_CANCEL:{code}", raw: "ORDER_CANCEL:X1", want: Params{"code": "X1"}, ok: true},
+		{pattern: "ORDER_CANCEL:{code}", raw: "ORDER_CANCEL", ok: false},
+		{pattern: "ORDER:cancel:{code}", raw: "ORDER:cancel:X1", want: Params{"code": "X1"}, ok: true},
+		{pattern: "ORDER:cancel:{code}", raw: "ORDER:keep:X1", ok: false},
+		{pattern: "ORDER_CANCEL:{code}", raw: `{"action":"ORDER_CANCEL","code":"X1"}`, want: Params{"code": "X1"}, ok: true},
+		{pattern: "ORDER_CANCEL:{code}", raw: `{"action":"ORDER_CANCEL"}`, ok: false},
+		{pattern: "ORDER_CANCEL:{code}", raw: "ORDER_CANCEL?code=X1", want: Params{"code": "X1"}, ok: true},
+		{pattern: "ORDER:cancel:{code}", raw: "action=ORDER&code=X1", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.raw, func(t *testing.T) {
+			rt := payloadRoute{segments: strings.Split(tt.pattern, ":")}
+
+			got, ok := rt.match(parsePayload(tt.raw))
+			if ok != tt.ok {
+				t.Fatalf("match() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuickRepliesFallThrough(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  string
+	}{
+		{
+			name:  "quick reply matching a route",
+			event: `"message":{"mid":"m1","text":"Cancel","quick_reply":{"payload":"ORDER_CANCEL:X1"}}`,
+			want:  "route X1",
+		},
+		{
+			name:  "quick reply matching no route",
+			event: `"message":{"mid":"m1","text":"Red","quick_reply":{"payload":"COLOR_RED"}}`,
+			want:  "message Red",
+		},
+		{
+			name:  "postback matching no route",
+			event: `"postback":{"title":"Red","payload":"COLOR_RED"}`,
+			want:  "default COLOR_RED",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Options{Logger: NopLogger})
+
+			var got []string
+			m.OnPostback("ORDER_CANCEL:{code}", func(p PostBack, params Params, r *Response) {
+				got = append(got, "route "+params["code"])
+			})
+			m.OnPostbackDefault(func(p PostBack, params Params, r *Response) {
+				got = append(got, "default "+p.Payload)
+			})
+			m.HandleMessage(func(msg Message, r *Response) {
+				got = append(got, "message "+msg.Text)
+			})
+
+			postEvents(t, m, "messaging", `"sender":{"id":"2"},"recipient":{"id":"1"},"timestamp":1,`+tt.event)
+
+			if want := []string{tt.want}; !reflect.DeepEqual(got, want) {
+				t.Errorf("handled %v, want %v", got, want)
+			}
+		})
+	}
+}
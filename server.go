@@ -0,0 +1,157 @@
+package messenger
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of ServeOptions.
+const (
+	DefaultReadTimeout     = 10 * time.Second
+	DefaultWriteTimeout    = 30 * time.Second
+	DefaultIdleTimeout     = 2 * time.Minute
+	DefaultShutdownTimeout = 30 * time.Second
+	DefaultDrainDelay      = 5 * time.Second
+)
+
+// ServeOptions are the settings of the server started by Serve.
+type ServeOptions struct {
+	// CertFile and KeyFile are the certificate and key used to serve TLS.
+	CertFile string
+	KeyFile  string
+	// GetCertificate, if not nil, supplies the certificates used to serve
+	// TLS instead of CertFile and KeyFile, eg. autocert.Manager's
+	// GetCertificate.
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// ReadTimeout, WriteTimeout and IdleTimeout are the timeouts of the
+	// server. 0 means DefaultReadTimeout, DefaultWriteTimeout and
+	// DefaultIdleTimeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout caps how long Serve waits for in-flight handlers and
+	// queued sends once ctx is done. 0 means DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long /readyz fails before Serve stops accepting
+	// connections once ctx is done, so that load balancers stop sending
+	// webhooks first. 0 means DefaultDrainDelay, a negative value none.
+	DrainDelay time.Duration
+	// Ready, if not nil, is checked by /readyz, eg. to ping a database. The
+	// server is not ready while it returns an error.
+	Ready func() error
+}
+
+// Serve serves the webhook on addr until ctx is done, along with /healthz,
+// which always succeeds while the server is up, and /readyz, which fails
+// once the server is shutting down. TLS is served when ServeOptions has a
+// certificate.
+//
+// When ctx is done, Serve fails /readyz for DrainDelay, stops accepting
+// connections, waits for in-flight handlers to return and for the outbox to be
+// sent, see Shutdown, then returns.
+func (m *Messenger) Serve(ctx context.Context, addr string, opts ServeOptions) error {
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = DefaultReadTimeout
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if opts.DrainDelay == 0 {
+		opts.DrainDelay = DefaultDrainDelay
+	}
+
+	var stopping atomic.Bool
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, "")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case stopping.Load():
+			writeStatus(w, http.StatusServiceUnavailable, "shutting down")
+		case opts.Ready != nil:
+			if err := opts.Ready(); err != nil {
+				writeStatus(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			fallthrough
+		default:
+			writeStatus(w, http.StatusOK, "")
+		}
+	})
+	mux.Handle("/", m.Handler())
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+	}
+
+	if opts.GetCertificate != nil {
+		srv.TLSConfig = &tls.Config{GetCertificate: opts.GetCertificate}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil || opts.CertFile != "" {
+			errc <- srv.ListenAndServeTLS(opts.CertFile, opts.KeyFile)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	m.log.Info("serving webhook", "addr", addr, "tls", srv.TLSConfig != nil || opts.CertFile != "")
+
+	// failed stops the Messenger when the server stops on its own, eg. when
+	// addr is in use, so that the outbox and forwards are not left running.
+	failed := func(err error) error {
+		sctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		defer cancel()
+
+		return errors.Join(err, m.Shutdown(sctx))
+	}
+
+	select {
+	case err := <-errc:
+		return failed(err)
+	case <-ctx.Done():
+	}
+
+	stopping.Store(true)
+	m.log.Info("shutting down webhook server", "addr", addr, "drain", opts.DrainDelay)
+
+	if opts.DrainDelay > 0 {
+		select {
+		case <-time.After(opts.DrainDelay):
+		case err := <-errc:
+			return failed(err)
+		}
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(sctx)
+	if serr := <-errc; !errors.Is(serr, http.ErrServerClosed) && err == nil {
+		err = serr
+	}
+
+	if oerr := m.Shutdown(sctx); err == nil {
+		err = oerr
+	}
+
+	return err
+}
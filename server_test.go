@@ -0,0 +1,31 @@
+package messenger
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeStopsOutboxWhenListenFails(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	m := New(Options{Logger: NopLogger, Outbox: NewMemoryStore()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := m.Serve(ctx, l.Addr().String(), ServeOptions{}); err == nil {
+		t.Fatalf("Serve() = nil, want an error for an address in use")
+	}
+
+	select {
+	case <-m.outbox.done:
+	default:
+		t.Errorf("outbox still running after Serve returned")
+	}
+}
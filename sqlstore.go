@@ -0,0 +1,173 @@
+package messenger
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLStore is an OutboxStore which keeps messages in a table of a SQL
+// database, so that queued messages survive restarts and can be inspected
+// with SQL. The table is created by CreateTable.
+type SQLStore struct {
+	// DB is the database the table is in.
+	DB *sql.DB
+	// Table is the name of the table.
+	Table string
+	// Dollar sets whether to use $1 style placeholders, as needed by
+	// PostgreSQL, instead of ?.
+	Dollar bool
+}
+
+// CreateTable creates the table of the store if it does not exist.
+func (s *SQLStore) CreateTable() error {
+	_, err := s.DB.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (
+	id VARCHAR(64) PRIMARY KEY,
+	page BIGINT NOT NULL,
+	recipient TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	body TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_error TEXT NOT NULL,
+	created BIGINT NOT NULL,
+	dead BOOLEAN NOT NULL
+)`, s.Table))
+	return err
+}
+
+// Put implements OutboxStore.
+func (s *SQLStore) Put(msg OutboxMessage) error {
+	return s.put(msg, false)
+}
+
+// Pending implements OutboxStore.
+func (s *SQLStore) Pending() ([]OutboxMessage, error) {
+	return s.list(false)
+}
+
+// Delete implements OutboxStore.
+func (s *SQLStore) Delete(id string) error {
+	return s.delete(id, false)
+}
+
+// PutDead implements OutboxStore.
+func (s *SQLStore) PutDead(msg OutboxMessage) error {
+	return s.put(msg, true)
+}
+
+// Dead implements OutboxStore.
+func (s *SQLStore) Dead() ([]OutboxMessage, error) {
+	return s.list(true)
+}
+
+// DeleteDead implements OutboxStore.
+func (s *SQLStore) DeleteDead(id string) error {
+	return s.delete(id, true)
+}
+
+// put inserts msg, replacing the row with the same ID. The delete and insert
+// are done in a transaction as upserts are not portable.
+func (s *SQLStore) put(msg OutboxMessage, dead bool) error {
+	recipient, err := json.Marshal(msg.Recipient)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(s.query("DELETE FROM %v WHERE id = ?"), msg.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		s.query("INSERT INTO %v (id, page, recipient, content_type, body, attempts, last_error, created, dead) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		msg.ID,
+		msg.Page,
+		string(recipient),
+		msg.ContentType,
+		base64.StdEncoding.EncodeToString(msg.Body),
+		msg.Attempts,
+		msg.LastError,
+		msg.Created.UnixNano(),
+		dead,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// list returns the messages in the queue, or the dead-letter list, in order of
+// ID.
+func (s *SQLStore) list(dead bool) ([]OutboxMessage, error) {
+	rows, err := s.DB.Query(s.query("SELECT id, page, recipient, content_type, body, attempts, last_error, created FROM %v WHERE dead = ? ORDER BY id"), dead)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []OutboxMessage
+	for rows.Next() {
+		var (
+			msg       OutboxMessage
+			recipient string
+			body      string
+			created   int64
+		)
+
+		err := rows.Scan(&msg.ID, &msg.Page, &recipient, &msg.ContentType, &body, &msg.Attempts, &msg.LastError, &created)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(recipient), &msg.Recipient); err != nil {
+			return nil, err
+		}
+
+		msg.Body, err = base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, err
+		}
+
+		msg.Created = time.Unix(0, created)
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, rows.Err()
+}
+
+// delete removes the message id from the queue, or the dead-letter list.
+func (s *SQLStore) delete(id string, dead bool) error {
+	_, err := s.DB.Exec(s.query("DELETE FROM %v WHERE id = ? AND dead = ?"), id, dead)
+	return err
+}
+
+// query fills the table name into q and rewrites its placeholders for the
+// database.
+func (s *SQLStore) query(q string) string {
+	q = fmt.Sprintf(q, s.Table)
+	if !s.Dollar {
+		return q
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&b, "$%v", n)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package messenger
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Webhook fields a page can be subscribed to.
+const (
+	FieldMessages        = "messages"
+	FieldMessageEchoes   = "message_echoes"
+	FieldMessageDelivery = "message_deliveries"
+	FieldMessageReads    = "message_reads"
+	FieldPostbacks       = "messaging_postbacks"
+	FieldOptIns          = "messaging_optins"
+	FieldReferrals       = "messaging_referrals"
+	FieldAccountLinking  = "messaging_account_linking"
+	FieldHandovers       = "messaging_handovers"
+	FieldStandby         = "standby"
+)
+
+// eventFields maps each type of event to the webhook field it is sent for.
+var eventFields = map[EventType]string{
+	MessageEvent:              FieldMessages,
+	EchoEvent:                 FieldMessageEchoes,
+	DeliveryEvent:             FieldMessageDelivery,
+	ReadEvent:                 FieldMessageReads,
+	PostBackEvent:             FieldPostbacks,
+	OptInEvent:                FieldOptIns,
+	ReferralEvent:             FieldReferrals,
+	AccountLinkingEvent:       FieldAccountLinking,
+	PassThreadControlEvent:    FieldHandovers,
+	TakeThreadControlEvent:    FieldHandovers,
+	RequestThreadControlEvent: FieldHandovers,
+}
+
+// SubscribedApp is an app subscribed to the webhook of a page.
+type SubscribedApp struct {
+	ID     int64    `json:"id,string"`
+	Name   string   `json:"name"`
+	Fields []string `json:"subscribed_fields"`
+}
+
+// Subscribe subscribes the app to the webhook fields of page, replacing the
+// fields it was subscribed to. A page of 0 means the default page.
+func (m *Messenger) Subscribe(ctx context.Context, page int64, fields ...string) error {
+	query := url.Values{}
+	query.Set("subscribed_fields", strings.Join(fields, ","))
+
+	return m.subscription(ctx, page, "POST", query)
+}
+
+// Unsubscribe unsubscribes the app from the webhook of page. A page of 0
+// means the default page.
+func (m *Messenger) Unsubscribe(ctx context.Context, page int64) error {
+	return m.subscription(ctx, page, "DELETE", nil)
+}
+
+// Subscriptions retrieves the apps subscribed to the webhook of page, along
+// with their fields. A page of 0 means the default page.
+func (m *Messenger) Subscriptions(ctx context.Context, page int64) ([]SubscribedApp, error) {
+	var res struct {
+		Data []SubscribedApp `json:"data"`
+	}
+
+	query := url.Values{}
+	query.Set("fields", "id,name,subscribed_fields")
+
+	err := m.graph(ctx, page, "GET", pagePath(page)+"/subscribed_apps", query, nil, &res)
+	return res.Data, err
+}
+
+// HandledFields returns the webhook fields of the events the Messenger has
+// handlers or routes for, in order.
+func (m *Messenger) HandledFields() []string {
+	set := map[string]bool{}
+	for t, handlers := range m.handlers {
+		if field, ok := eventFields[t]; ok && len(handlers) > 0 {
+			set[field] = true
+		}
+	}
+
+	if m.includeEchoes && len(m.handlers[MessageEvent]) > 0 {
+		set[FieldMessageEchoes] = true
+	}
+	if len(m.texts.routes) > 0 {
+		set[FieldMessages] = true
+	}
+	if len(m.payloads.routes) > 0 || m.payloads.fallback != nil {
+		set[FieldPostbacks] = true
+	}
+	if len(m.standby) > 0 {
+		set[FieldStandby] = true
+	}
+
+	fields := make([]string, 0, len(set))
+	for field := range set {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// CheckSubscriptions compares the webhook fields the app is subscribed to on
+// page with HandledFields, logging a warning if some are missing, and returns
+// the missing fields. It is meant to be called on startup, once the handlers
+// are added. A page of 0 means the default page.
+func (m *Messenger) CheckSubscriptions(ctx context.Context, page int64) ([]string, error) {
+	var app App
+	if err := m.graph(ctx, page, "GET", "app", nil, nil, &app); err != nil {
+		return nil, err
+	}
+
+	apps, err := m.Subscriptions(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribed := map[string]bool{}
+	for _, a := range apps {
+		if a.ID != app.ID {
+			continue
+		}
+		for _, field := range a.Fields {
+			subscribed[field] = true
+		}
+	}
+
+	var missing []string
+	for _, field := range m.HandledFields() {
+		if !subscribed[field] {
+			missing = append(missing, field)
+		}
+	}
+
+	if len(missing) > 0 {
+		m.log.Warn("webhook fields not subscribed", "page", page, "app", app.ID, "fields", strings.Join(missing, ","))
+	}
+
+	return missing, nil
+}
+
+// subscription sends a request to the subscribed_apps endpoint of page.
+func (m *Messenger) subscription(ctx context.Context, page int64, method string, query url.Values) error {
+	var res struct {
+		Success bool `json:"success"`
+	}
+
+	err := m.graph(ctx, page, method, pagePath(page)+"/subscribed_apps", query, nil, &res)
+	if err != nil {
+		return err
+	}
+
+	if !res.Success {
+		return &Error{Message: "subscribed_apps was not successful"}
+	}
+
+	return nil
+}
+
+// pagePath returns the Graph API path of page, where 0 means the default
+// page.
+func pagePath(page int64) string {
+	if page == 0 {
+		return "me"
+	}
+	return strconv.FormatInt(page, 10)
+}
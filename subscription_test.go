@@ -0,0 +1,56 @@
+package messenger
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHandledFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		setup   func(m *Messenger)
+		want    []string
+	}{
+		{
+			name:  "nothing handled",
+			setup: func(m *Messenger) {},
+			want:  []string{},
+		},
+		{
+			name:    "echoes included without handlers",
+			options: Options{IncludeEchoes: true},
+			setup:   func(m *Messenger) {},
+			want:    []string{},
+		},
+		{
+			name:    "echoes included with message handler",
+			options: Options{IncludeEchoes: true},
+			setup: func(m *Messenger) {
+				m.HandleMessage(func(Message, *Response) {})
+			},
+			want: []string{FieldMessageEchoes, FieldMessages},
+		},
+		{
+			name: "handlers and routes",
+			setup: func(m *Messenger) {
+				m.HandleRead(func(Read, *Response) {})
+				m.OnPostbackDefault(func(PostBack, Params, *Response) {})
+				m.HandleStandby(func(Event, *Response) {})
+			},
+			want: []string{FieldMessageReads, FieldPostbacks, FieldStandby},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.Logger = NopLogger
+			m := New(tt.options)
+			tt.setup(m)
+
+			if got := m.HandledFields(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HandledFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
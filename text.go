@@ -0,0 +1,191 @@
+package messenger
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// TextHandler is a handler used for responding to a text message which was
+// matched by a TextRule.
+type TextHandler func(Message, Params, *Response)
+
+// TextRule decides which text messages are handled by a TextHandler.
+type TextRule struct {
+	match    func(string) (Params, bool)
+	when     func(Message) bool
+	priority int
+	global   bool
+}
+
+// textRoute is a single rule registered with OnText.
+type textRoute struct {
+	rule    TextRule
+	handler TextHandler
+}
+
+// textRouter matches the text of incoming messages against rules.
+type textRouter struct {
+	routes []textRoute
+}
+
+// Exact creates a TextRule matching messages whose text is exactly s, ignoring
+// surrounding whitespace.
+func Exact(s string) TextRule {
+	s = strings.TrimSpace(s)
+	return TextRule{
+		match: func(text string) (Params, bool) {
+			return Params{}, text == s
+		},
+	}
+}
+
+// IgnoreCase creates a TextRule matching messages whose text is s in any case.
+func IgnoreCase(s string) TextRule {
+	s = strings.TrimSpace(s)
+	return TextRule{
+		match: func(text string) (Params, bool) {
+			return Params{}, strings.EqualFold(text, s)
+		},
+	}
+}
+
+// IgnoreAccents creates a TextRule matching messages whose text is s in any
+// case and with or without diacritics. IgnoreAccents("huy") matches "Hủy".
+func IgnoreAccents(s string) TextRule {
+	s = unaccent(strings.TrimSpace(s))
+	return TextRule{
+		match: func(text string) (Params, bool) {
+			return Params{}, unaccent(text) == s
+		},
+	}
+}
+
+// Regexp creates a TextRule matching messages against the regular expression
+// expr. The values of named captures, eg. (?P<code>\w+), are passed on as
+// params. Regexp panics if expr cannot be compiled.
+func Regexp(expr string) TextRule {
+	re := regexp.MustCompile(expr)
+	return TextRule{
+		match: func(text string) (Params, bool) {
+			sub := re.FindStringSubmatch(text)
+			if sub == nil {
+				return nil, false
+			}
+
+			params := Params{}
+			for i, name := range re.SubexpNames() {
+				if name != "" {
+					params[name] = sub[i]
+				}
+			}
+			return params, true
+		},
+	}
+}
+
+// Priority returns a copy of the rule which is tried before rules with a lower
+// priority. Rules have a priority of 0 by default.
+func (t TextRule) Priority(p int) TextRule {
+	t.priority = p
+	return t
+}
+
+// Global returns a copy of the rule marked as a global command, such as "help"
+// or "menu". Global rules are tried before all other rules, whatever their
+// priority or conditions, so they always win over the current conversation
+// step.
+func (t TextRule) Global() TextRule {
+	t.global = true
+	return t
+}
+
+// When returns a copy of the rule which only matches messages for which f
+// returns true, eg. messages sent during a given conversation step.
+func (t TextRule) When(f func(Message) bool) TextRule {
+	t.when = f
+	return t
+}
+
+// OnText adds a TextHandler to the Messenger which will be triggered when the
+// text of a message matches rule.
+//
+// Global rules are tried first, followed by the other rules from highest to
+// lowest priority. Rules of equal priority are tried in the order they were
+// added. Only the first matching rule is triggered, and messages which are
+// handled by a rule are not passed on to the MessageHandlers.
+func (m *Messenger) OnText(rule TextRule, f TextHandler) {
+	m.texts.routes = append(m.texts.routes, textRoute{rule: rule, handler: f})
+
+	sort.SliceStable(m.texts.routes, func(i, j int) bool {
+		a, b := m.texts.routes[i].rule, m.texts.routes[j].rule
+		if a.global != b.global {
+			return a.global
+		}
+		return a.priority > b.priority
+	})
+}
+
+// route triggers the handler of the first rule matching msg. It returns false
+// if no rule matched.
+func (tr *textRouter) route(msg Message, r *Response) bool {
+	text := strings.TrimSpace(msg.Text)
+	if text == "" {
+		return false
+	}
+
+	for _, rt := range tr.routes {
+		if rt.rule.when != nil && !rt.rule.when(msg) {
+			continue
+		}
+
+		if params, ok := rt.rule.match(text); ok {
+			rt.handler(msg, params, r)
+			return true
+		}
+	}
+
+	return false
+}
+
+// unaccent lower cases s and strips its diacritics, including the Vietnamese
+// đ, so that "Hủy Đơn" becomes "huy don".
+func unaccent(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(s) {
+		// Combining marks are left behind by decomposed (NFD) text.
+		if unicode.Is(unicode.Mn, c) {
+			continue
+		}
+
+		if base, ok := accents[c]; ok {
+			c = base
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// accents maps lower case accented letters to their base letter.
+var accents = func() map[rune]rune {
+	groups := map[rune]string{
+		'a': "àáạảãâầấậẩẫăằắặẳẵäåā",
+		'e': "èéẹẻẽêềếệểễëē",
+		'i': "ìíịỉĩîïī",
+		'o': "òóọỏõôồốộổỗơờớợởỡöøō",
+		'u': "ùúụủũûưừứựửữüū",
+		'y': "ỳýỵỷỹÿ",
+		'd': "đ",
+		'c': "ç",
+		'n': "ñ",
+	}
+
+	m := map[rune]rune{}
+	for base, letters := range groups {
+		for _, c := range letters {
+			m[c] = base
+		}
+	}
+	return m
+}()
@@ -0,0 +1,93 @@
+package messenger
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestUnaccent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: ""},
+		{in: "menu", want: "menu"},
+		{in: "Hủy Đơn", want: "huy don"},
+		{in: "TIẾNG VIỆT", want: "tieng viet"},
+		{in: "Xin chào, bạn khỏe không?", want: "xin chao, ban khoe khong?"},
+		// Decomposed (NFD) text carries its accents as combining marks.
+		{in: "Ho\u0302\u0300 Chi\u0301 Minh", want: "ho chi minh"},
+		{in: "Crème brûlée, señor", want: "creme brulee, senor"},
+		{in: "Île", want: "ile"},
+		{in: "日本語 ok", want: "日本語 ok"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := unaccent(tt.in); got != tt.want {
+				t.Errorf("unaccent(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextRouting(t *testing.T) {
+	tests := []struct {
+		name   string
+		sender int64
+		text   string
+		want   string
+		params Params
+	}{
+		{name: "regexp captures", sender: 2, text: "order 42", want: "order", params: Params{"code": "42"}},
+		{name: "added first wins", sender: 2, text: "order 1", want: "order", params: Params{"code": "1"}},
+		{name: "higher priority", sender: 3, text: "order 42", want: "step order", params: Params{}},
+		{name: "when", sender: 4, text: "order 42", want: "step", params: Params{}},
+		{name: "global over priority", sender: 4, text: "HELP", want: "help", params: Params{}},
+		{name: "lower priority", sender: 2, text: "Hủy", want: "cancel", params: Params{}},
+		{name: "unrouted", sender: 2, text: "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Options{Logger: NopLogger})
+
+			var (
+				got     string
+				params  Params
+				handled bool
+			)
+			route := func(name string) TextHandler {
+				return func(msg Message, p Params, r *Response) {
+					got, params = name, p
+				}
+			}
+			sender := func(id int64) func(Message) bool {
+				return func(msg Message) bool { return msg.Sender.ID == id }
+			}
+
+			m.OnText(Regexp(`^order (?P<code>\w+)$`), route("order"))
+			m.OnText(Exact("order 1"), route("order 1"))
+			m.OnText(Regexp(`^order`).Priority(2).When(sender(3)), route("step order"))
+			m.OnText(IgnoreAccents("huy").Priority(-1), route("cancel"))
+			m.OnText(Regexp(`.*`).Priority(10).When(sender(4)), route("step"))
+			m.OnText(IgnoreCase("help").Global(), route("help"))
+			m.HandleMessage(func(msg Message, r *Response) {
+				handled = true
+			})
+
+			postEvents(t, m, "messaging", `"sender":{"id":"`+strconv.FormatInt(tt.sender, 10)+`"},"recipient":{"id":"1"},"timestamp":1,"message":{"mid":"m1","text":"`+tt.text+`"}`)
+
+			if got != tt.want {
+				t.Errorf("routed %q to %q, want %q", tt.text, got, tt.want)
+			}
+			if !reflect.DeepEqual(params, tt.params) {
+				t.Errorf("params = %v, want %v", params, tt.params)
+			}
+			if handled != (tt.want == "") {
+				t.Errorf("HandleMessage called = %v, want %v", handled, tt.want == "")
+			}
+		})
+	}
+}
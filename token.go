@@ -0,0 +1,144 @@
+package messenger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces secrets in errors and printed values.
+const redacted = "REDACTED"
+
+// errNoToken is returned when there is no token to make a request with.
+var errNoToken = errors.New("messenger: no page token configured")
+
+// TokenProvider supplies the access token of a page. It is asked for the token
+// on every request, so tokens can be rotated without a restart.
+type TokenProvider interface {
+	Token() (string, error)
+}
+
+// StaticToken is a TokenProvider which always returns the same token.
+type StaticToken string
+
+// Token implements TokenProvider.
+func (t StaticToken) Token() (string, error) {
+	if t == "" {
+		return "", errNoToken
+	}
+	return string(t), nil
+}
+
+// String hides the token when it is printed.
+func (t StaticToken) String() string {
+	return redacted
+}
+
+// EnvToken is a TokenProvider which reads the token from the environment
+// variable it names on every request.
+type EnvToken string
+
+// Token implements TokenProvider.
+func (e EnvToken) Token() (string, error) {
+	token := os.Getenv(string(e))
+	if token == "" {
+		return "", fmt.Errorf("messenger: environment variable %v is empty", string(e))
+	}
+	return token, nil
+}
+
+// fileToken is the TokenProvider returned by FileToken.
+type fileToken struct {
+	path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+}
+
+// FileToken creates a TokenProvider which reads the token from the file at
+// path, eg. a mounted secret. The file is read again whenever it changes.
+func FileToken(path string) TokenProvider {
+	return &fileToken{path: path}
+}
+
+// Token implements TokenProvider.
+func (f *fileToken) Token() (string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.token != "" && info.ModTime().Equal(f.modTime) {
+		return f.token, nil
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("messenger: token file %v is empty", f.path)
+	}
+
+	f.token = token
+	f.modTime = info.ModTime()
+
+	return f.token, nil
+}
+
+// PageTokenFunc is an adapter which allows a function, eg. a lookup in a
+// secrets store, to be used as a PageTokenProvider.
+type PageTokenFunc func(page int64) (string, error)
+
+// PageToken implements PageTokenProvider.
+func (f PageTokenFunc) PageToken(page int64) (string, error) {
+	return f(page)
+}
+
+// appSecretProof returns the appsecret_proof of token, which proves that
+// requests made with token come from the app.
+func appSecretProof(secret, token string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// secretParams are the query parameters which are hidden from errors.
+var secretParams = []string{"access_token", "appsecret_proof", "account_linking_token"}
+
+// redactError hides the secret query parameters of the URL in err, which
+// net/http includes in its errors.
+func redactError(err error) error {
+	var ue *url.Error
+	if !errors.As(err, &ue) {
+		return err
+	}
+
+	u, perr := url.Parse(ue.URL)
+	if perr != nil {
+		return &url.Error{Op: ue.Op, URL: redacted, Err: ue.Err}
+	}
+
+	query := u.Query()
+	for _, p := range secretParams {
+		if query.Get(p) != "" {
+			query.Set(p, redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return &url.Error{Op: ue.Op, URL: u.String(), Err: ue.Err}
+}
@@ -0,0 +1,113 @@
+package messenger
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppSecretProof(t *testing.T) {
+	want := "e941110e3d2bfe82621f0e3e1434730d7305d106c5f68c87165d0b27a4611a4a"
+	if got := appSecretProof("secret", "token"); got != want {
+		t.Errorf("appSecretProof() = %v, want %v", got, want)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	secrets := []string{"tok3n", "pr00f", "l1nk"}
+
+	err := &url.Error{
+		Op:  "Get",
+		URL: GraphURL + "me?access_token=tok3n&appsecret_proof=pr00f&account_linking_token=l1nk&fields=recipient",
+		Err: errors.New("connection refused"),
+	}
+
+	got := redactError(err)
+	for _, s := range secrets {
+		if strings.Contains(got.Error(), s) {
+			t.Errorf("redactError() = %q, contains %q", got, s)
+		}
+	}
+	if !strings.Contains(got.Error(), "fields=recipient") || !strings.Contains(got.Error(), "connection refused") {
+		t.Errorf("redactError() = %q, lost the details of the error", got)
+	}
+
+	plain := errors.New("plain")
+	if got := redactError(plain); got != plain {
+		t.Errorf("redactError() = %v, want %v", got, plain)
+	}
+}
+
+func TestFileToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	write := func(token string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := FileToken(path)
+
+	if _, err := p.Token(); err == nil {
+		t.Errorf("Token() of a missing file = nil error")
+	}
+
+	now := time.Now()
+	write("first\n", now)
+	if got, err := p.Token(); got != "first" || err != nil {
+		t.Errorf("Token() = %q, %v, want first", got, err)
+	}
+
+	write("second\n", now.Add(time.Second))
+	if got, err := p.Token(); got != "second" || err != nil {
+		t.Errorf("Token() after rotation = %q, %v, want second", got, err)
+	}
+
+	write("  \n", now.Add(2*time.Second))
+	if _, err := p.Token(); err == nil {
+		t.Errorf("Token() of an empty file = nil error")
+	}
+}
+
+func TestPageToken(t *testing.T) {
+	pages := PageTokens{1: "page1"}
+
+	tests := []struct {
+		name    string
+		options Options
+		page    int64
+		want    string
+		wantErr bool
+	}{
+		{name: "default page", options: Options{Token: "default"}, page: 0, want: "default"},
+		{name: "page token", options: Options{Token: "default", PageTokens: pages}, page: 1, want: "page1"},
+		{name: "unknown page", options: Options{Token: "default", PageTokens: pages}, page: 2, wantErr: true},
+		{name: "default page with page tokens", options: Options{Token: "default", PageTokens: pages}, page: 0, want: "default"},
+		{name: "page without page tokens", options: Options{Token: "default"}, page: 1, want: "default"},
+		{name: "provider over token", options: Options{Token: "default", TokenProvider: StaticToken("provided")}, page: 0, want: "provided"},
+		{name: "only page tokens", options: Options{PageTokens: PageTokens{0: "zero"}}, page: 0, want: "zero"},
+		{name: "no token", options: Options{}, page: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.Logger = NopLogger
+			m := New(tt.options)
+
+			got, err := m.pageToken(tt.page)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pageToken(%v) error = %v, want error %v", tt.page, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("pageToken(%v) = %q, want %q", tt.page, got, tt.want)
+			}
+		})
+	}
+}
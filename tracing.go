@@ -0,0 +1,97 @@
+package messenger
+
+import (
+	"context"
+	"net/http"
+)
+
+// Names of the span attributes set by the Messenger.
+const (
+	AttributePage   = "messenger.page"
+	AttributeSender = "messenger.sender"
+	AttributeMid    = "messenger.mid"
+	AttributeAction = "messenger.action"
+)
+
+// Attribute is a key-value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts spans, see Options.Tracer. It follows the shape of the
+// OpenTelemetry tracing API, so an adapter for an OpenTelemetry tracer only
+// has to convert the attributes.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx, if any,
+	// and returns a context carrying the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is an operation started by a Tracer.
+type Span interface {
+	// SetAttributes adds attributes to the span.
+	SetAttributes(attrs ...Attribute)
+	// RecordError marks the span as failed with err.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// nopTracer is the Tracer used when Options.Tracer is nil.
+type nopTracer struct{}
+
+// nopSpan is the Span started by nopTracer.
+type nopSpan struct{}
+
+func (nopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopSpan) SetAttributes(...Attribute) {}
+func (nopSpan) RecordError(error)          {}
+func (nopSpan) End()                       {}
+
+// eventAttributes returns the attributes describing e.
+func eventAttributes(e Event) []Attribute {
+	return []Attribute{
+		{AttributePage, e.PageID},
+		{AttributeSender, e.Sender.ID},
+		{AttributeMid, eventMid(e)},
+		{AttributeAction, eventAction(e)},
+	}
+}
+
+// eventAction returns the name of the payload of a postback or quick reply,
+// or the type of any other event.
+func eventAction(e Event) string {
+	switch p := e.Payload.(type) {
+	case PostBack:
+		return parsePayload(p.Payload).name
+	case Message:
+		if p.QuickReply != nil {
+			return parsePayload(p.QuickReply.Payload).name
+		}
+	}
+	return string(e.Type)
+}
+
+// traced runs f with a copy of r which carries a span named name, started as a
+// child of the span of r.
+func (m *Messenger) traced(name string, e Event, r *Response, f func(*Response)) {
+	ctx, span := m.tracer.Start(r.Context(), name, eventAttributes(e)...)
+	defer span.End()
+
+	c := *r
+	c.ctx = ctx
+	f(&c)
+}
+
+// traceRequest starts a span for a Graph API request.
+func (m *Messenger) traceRequest(ctx context.Context, page int64, req *http.Request) (context.Context, Span) {
+	return m.tracer.Start(ctx, "messenger.graph",
+		Attribute{AttributePage, page},
+		Attribute{"http.method", req.Method},
+		Attribute{"http.url", req.URL.Host + req.URL.Path},
+	)
+}
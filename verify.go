@@ -0,0 +1,91 @@
+package messenger
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// verifyAlertAt is the number of consecutive failed verification requests
+// from which each failure is logged as an error rather than a warning.
+const verifyAlertAt = 5
+
+// verifyState tracks whether the verification handshake is allowed, and the
+// failed verification requests.
+type verifyState struct {
+	mu       sync.Mutex
+	until    time.Time
+	failures int
+}
+
+// AllowVerify allows the verification handshake for d outside of verify mode,
+// eg. while subscribing the webhook again from the Facebook Developer Portal.
+func (m *Messenger) AllowVerify(d time.Duration) {
+	m.verifyState.mu.Lock()
+	defer m.verifyState.mu.Unlock()
+
+	m.verifyState.until = time.Now().Add(d)
+}
+
+// verifyActive is whether verification requests are answered.
+func (m *Messenger) verifyActive() bool {
+	if m.verifyMode {
+		return true
+	}
+
+	m.verifyState.mu.Lock()
+	defer m.verifyState.mu.Unlock()
+
+	return time.Now().Before(m.verifyState.until)
+}
+
+// verify answers the request Facebook sends to verify the webhook when it is
+// set up.
+func (m *Messenger) verify(w http.ResponseWriter, r *http.Request) {
+	if !m.verifyActive() {
+		m.reject(w, r, http.StatusMethodNotAllowed, ErrVerifyDisabled)
+		return
+	}
+
+	token := r.FormValue("hub.verify_token")
+
+	var err error
+	switch {
+	case r.FormValue("hub.mode") != "subscribe":
+		err = ErrVerifyMode
+	case m.verifyToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(m.verifyToken)) != 1:
+		err = ErrVerifyToken
+	}
+
+	if err != nil {
+		m.verifyFailed(r)
+		m.reject(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	m.verifyState.mu.Lock()
+	m.verifyState.failures = 0
+	m.verifyState.mu.Unlock()
+
+	m.log.Info("verified webhook", "remote", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, r.FormValue("hub.challenge"))
+}
+
+// verifyFailed records a failed verification request, raising the level of
+// the log line once they keep failing.
+func (m *Messenger) verifyFailed(r *http.Request) {
+	m.verifyState.mu.Lock()
+	m.verifyState.failures++
+	failures := m.verifyState.failures
+	m.verifyState.mu.Unlock()
+
+	m.metrics.Count(MetricVerifyFailures, nil)
+
+	if failures >= verifyAlertAt {
+		m.log.Error("repeated failed webhook verification", "failures", failures, "remote", r.RemoteAddr)
+	}
+}
@@ -0,0 +1,82 @@
+package messenger
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyRequest sends a verification request to the Handler of m.
+func verifyRequest(m *Messenger, mode, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/?hub.mode="+mode+"&hub.verify_token="+token+"&hub.challenge=1234", nil)
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, req)
+	return w
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		allow   time.Duration
+		mode    string
+		token   string
+		status  int
+	}{
+		{name: "verify mode", options: Options{Verify: true, VerifyToken: "token"}, mode: "subscribe", token: "token", status: http.StatusOK},
+		{name: "outside verify mode", options: Options{VerifyToken: "token"}, mode: "subscribe", token: "token", status: http.StatusMethodNotAllowed},
+		{name: "allowed window", options: Options{VerifyToken: "token"}, allow: time.Minute, mode: "subscribe", token: "token", status: http.StatusOK},
+		{name: "expired window", options: Options{VerifyToken: "token"}, allow: -time.Minute, mode: "subscribe", token: "token", status: http.StatusMethodNotAllowed},
+		{name: "wrong mode", options: Options{Verify: true, VerifyToken: "token"}, mode: "unsubscribe", token: "token", status: http.StatusForbidden},
+		{name: "wrong token", options: Options{Verify: true, VerifyToken: "token"}, mode: "subscribe", token: "other", status: http.StatusForbidden},
+		{name: "empty verify token", options: Options{Verify: true}, mode: "subscribe", token: "", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.Logger = NopLogger
+			m := New(tt.options)
+			if tt.allow != 0 {
+				m.AllowVerify(tt.allow)
+			}
+
+			w := verifyRequest(m, tt.mode, tt.token)
+			if w.Code != tt.status {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && strings.TrimSpace(w.Body.String()) != "1234" {
+				t.Errorf("body = %q, want the challenge", w.Body)
+			}
+		})
+	}
+}
+
+func TestVerifyFailuresEscalate(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	m := New(Options{Verify: true, VerifyToken: "token", Logger: logger})
+
+	for i := 1; i < verifyAlertAt; i++ {
+		verifyRequest(m, "subscribe", "wrong")
+	}
+	if strings.Contains(buf.String(), "level=ERROR") {
+		t.Fatalf("error logged after %v failures:\n%s", verifyAlertAt-1, buf.String())
+	}
+
+	verifyRequest(m, "subscribe", "wrong")
+	if !strings.Contains(buf.String(), `level=ERROR msg="repeated failed webhook verification"`) {
+		t.Fatalf("no error logged after %v failures:\n%s", verifyAlertAt, buf.String())
+	}
+
+	// A successful verification resets the count.
+	buf.Reset()
+	verifyRequest(m, "subscribe", "token")
+	verifyRequest(m, "subscribe", "wrong")
+	if strings.Contains(buf.String(), "level=ERROR") {
+		t.Errorf("error logged after a successful verification:\n%s", buf.String())
+	}
+}
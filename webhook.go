@@ -0,0 +1,132 @@
+package messenger
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodySize is the largest webhook body accepted when
+// Options.MaxBodySize is 0.
+const DefaultMaxBodySize = 1 << 20
+
+// Errors passed to Options.OnError when a webhook request is rejected.
+var (
+	// ErrMethod is returned for requests which are neither GET nor POST.
+	ErrMethod = errors.New("messenger: method not allowed")
+	// ErrContentType is returned for webhooks whose body is not JSON.
+	ErrContentType = errors.New("messenger: content type is not application/json")
+	// ErrBodyTooLarge is returned for webhooks larger than
+	// Options.MaxBodySize.
+	ErrBodyTooLarge = errors.New("messenger: body too large")
+	// ErrSignature is returned for webhooks whose X-Hub-Signature does not
+	// match the body.
+	ErrSignature = errors.New("messenger: invalid signature")
+	// ErrVerifyToken is returned for verification requests with the wrong
+	// verify token.
+	ErrVerifyToken = errors.New("messenger: invalid verify token")
+	// ErrVerifyMode is returned for verification requests whose hub.mode is
+	// not subscribe.
+	ErrVerifyMode = errors.New("messenger: hub.mode is not subscribe")
+	// ErrVerifyDisabled is returned for verification requests outside of
+	// verify mode, see Options.Verify and Messenger.AllowVerify.
+	ErrVerifyDisabled = errors.New("messenger: verification is disabled")
+)
+
+// ErrorHandler is a handler used for reporting webhook requests which were
+// rejected with status.
+type ErrorHandler func(r *http.Request, status int, err error)
+
+// readWebhook reads the body of a webhook, checking its content type, size and
+// signature. It returns the HTTP status to reject the request with on error.
+func (m *Messenger) readWebhook(w http.ResponseWriter, r *http.Request) ([]byte, int, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || mt != "application/json" {
+			return nil, http.StatusUnsupportedMediaType, ErrContentType
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, m.maxBodySize))
+	if err != nil {
+		var me *http.MaxBytesError
+		if errors.As(err, &me) {
+			return nil, http.StatusRequestEntityTooLarge, ErrBodyTooLarge
+		}
+		return nil, http.StatusBadRequest, err
+	}
+
+	if m.appSecret != "" && !validSignature(m.appSecret, r.Header, body) {
+		return nil, http.StatusForbidden, ErrSignature
+	}
+
+	return body, http.StatusOK, nil
+}
+
+// validSignature is whether the X-Hub-Signature-256 header, or the
+// X-Hub-Signature header if it is missing, is the signature of body with
+// secret.
+func validSignature(secret string, h http.Header, body []byte) bool {
+	sig, algo, hasher := h.Get("X-Hub-Signature-256"), "sha256=", sha256.New
+	if sig == "" {
+		sig, algo, hasher = h.Get("X-Hub-Signature"), "sha1=", sha1.New
+	}
+
+	if !strings.HasPrefix(sig, algo) {
+		return false
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, algo))
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(got, sign(hasher, secret, body))
+}
+
+// sign returns the HMAC of body with secret.
+func sign(hasher func() hash.Hash, secret string, body []byte) []byte {
+	mac := hmac.New(hasher, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// reject replies to a webhook request with status, reporting err to the
+// OnError hook.
+func (m *Messenger) reject(w http.ResponseWriter, r *http.Request, status int, err error) {
+	m.log.Warn("rejected webhook request", "status", status, "error", err, "remote", r.RemoteAddr)
+
+	if m.onError != nil {
+		m.onError(r, status, err)
+	}
+
+	if status == http.StatusMethodNotAllowed {
+		if m.verifyActive() {
+			w.Header().Set("Allow", "GET, POST")
+		} else {
+			w.Header().Set("Allow", "POST")
+		}
+	}
+
+	writeStatus(w, status, err.Error())
+}
+
+// writeStatus replies with status and a JSON body describing it.
+func writeStatus(w http.ResponseWriter, status int, message string) {
+	body := map[string]string{"status": "ok"}
+	if status != http.StatusOK {
+		body = map[string]string{"status": "error", "error": message}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,171 @@
+package messenger
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidSignature(t *testing.T) {
+	const secret = "secret"
+	body := []byte(`{"object":"page","entry":[]}`)
+
+	sha1Sig := "sha1=" + hex.EncodeToString(sign(sha1.New, secret, body))
+	sha256Sig := "sha256=" + hex.EncodeToString(sign(sha256.New, secret, body))
+	otherSig := "sha256=" + hex.EncodeToString(sign(sha256.New, "other", body))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{
+			name:    "sha256",
+			headers: map[string]string{"X-Hub-Signature-256": sha256Sig},
+			want:    true,
+		},
+		{
+			name:    "sha1",
+			headers: map[string]string{"X-Hub-Signature": sha1Sig},
+			want:    true,
+		},
+		{
+			name:    "sha256 preferred over sha1",
+			headers: map[string]string{"X-Hub-Signature-256": otherSig, "X-Hub-Signature": sha1Sig},
+			want:    false,
+		},
+		{
+			name:    "wrong secret",
+			headers: map[string]string{"X-Hub-Signature-256": otherSig},
+			want:    false,
+		},
+		{
+			name:    "wrong algorithm",
+			headers: map[string]string{"X-Hub-Signature-256": "sha1=" + sha256Sig[len("sha256="):]},
+			want:    false,
+		},
+		{
+			name:    "not hex",
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=zz"},
+			want:    false,
+		},
+		{
+			name:    "missing",
+			headers: map[string]string{},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+
+			if got := validSignature(secret, h, body); got != tt.want {
+				t.Errorf("validSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// signWebhook sets the signature headers of req for body with secret, as
+// Facebook would.
+func signWebhook(req *http.Request, secret string, body []byte) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(sign(sha1.New, secret, body)))
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign(sha256.New, secret, body)))
+}
+
+func TestWebhookStatus(t *testing.T) {
+	const secret = "secret"
+	valid := `{"object":"page","entry":[]}`
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		// sign signs the body with secret, otherwise with another secret.
+		sign    bool
+		status  int
+		wantErr error
+	}{
+		{name: "valid", method: "POST", body: valid, sign: true, status: http.StatusOK},
+		{name: "no content type", method: "POST", contentType: "-", body: valid, sign: true, status: http.StatusOK},
+		{name: "json with charset", method: "POST", contentType: "application/json; charset=utf-8", body: valid, sign: true, status: http.StatusOK},
+		{name: "bad json", method: "POST", body: `{"object":`, sign: true, status: http.StatusBadRequest},
+		{name: "bad signature", method: "POST", body: valid, status: http.StatusForbidden, wantErr: ErrSignature},
+		{name: "other method", method: "PUT", body: valid, sign: true, status: http.StatusMethodNotAllowed, wantErr: ErrMethod},
+		{name: "oversize body", method: "POST", body: `{"object":"page","entry":[],"pad":"` + strings.Repeat("x", 1024) + `"}`, sign: true, status: http.StatusRequestEntityTooLarge, wantErr: ErrBodyTooLarge},
+		{name: "wrong content type", method: "POST", contentType: "text/plain", body: valid, sign: true, status: http.StatusUnsupportedMediaType, wantErr: ErrContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reported []int
+			var reportedErr error
+			m := New(Options{
+				AppSecret:   secret,
+				MaxBodySize: 512,
+				Logger:      NopLogger,
+				OnError: func(r *http.Request, status int, err error) {
+					reported = append(reported, status)
+					reportedErr = err
+				},
+			})
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			if tt.sign {
+				signWebhook(req, secret, []byte(tt.body))
+			} else {
+				signWebhook(req, "other", []byte(tt.body))
+			}
+			switch tt.contentType {
+			case "":
+			case "-":
+				req.Header.Del("Content-Type")
+			default:
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			w := httptest.NewRecorder()
+			m.Handler().ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.status, w.Body)
+			}
+
+			var body struct {
+				Status string `json:"status"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Errorf("could not decode reply: %v", err)
+			}
+
+			if tt.status == http.StatusOK {
+				if body.Status != "ok" || len(reported) != 0 {
+					t.Errorf("reply status = %q, OnError called with %v", body.Status, reported)
+				}
+				return
+			}
+
+			if body.Status != "error" {
+				t.Errorf("reply status = %q, want error", body.Status)
+			}
+			if len(reported) != 1 || reported[0] != tt.status {
+				t.Errorf("OnError called with %v, want [%v]", reported, tt.status)
+			}
+			if tt.wantErr != nil && !errors.Is(reportedErr, tt.wantErr) {
+				t.Errorf("OnError error = %v, want %v", reportedErr, tt.wantErr)
+			}
+		})
+	}
+}